@@ -0,0 +1,99 @@
+// Package churn scores how likely a user is to stop playing from the shape
+// of their recent activity.
+package churn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is how far back from the evaluation time activity is read. Its two
+// halves are compared to tell a slowing user from a steady one.
+const Window = 90 * 24 * time.Hour
+
+// Risk bands, from the lowest score up.
+const (
+	BandLow    = "low"
+	BandMedium = "medium"
+	BandHigh   = "high"
+)
+
+// Lower bounds of the medium and high bands.
+const (
+	mediumFrom = 0.4
+	highFrom   = 0.7
+)
+
+// Weights of the logistic model. They are set by hand so that a user who
+// plays weekly scores low and one silent for a month with a single action
+// before scores around the medium band, not fitted to data.
+const (
+	intercept     = -1.5
+	recencyWeight = 0.05  // per day since the last action
+	actionsWeight = -0.5  // per log of actions in the window
+	betsWeight    = -0.25 // per log of bets in the window
+	topUpWeight   = -2.0  // per unit of TopUpTrend above 0.5
+	gapWeight     = 0.6   // per log of GapTrend
+)
+
+// Features are the recency, frequency and monetary inputs of Score.
+type Features struct {
+	// RecencyDays is the time since the last action.
+	RecencyDays float64 `json:"recencyDays"`
+	// Actions and Bets are counted over the Window.
+	Actions int `json:"actions"`
+	Bets    int `json:"bets"`
+	// TopUpTrend is the share of the top-up amount of the Window that fell in
+	// its recent half: 0.5 is steady, 0 means the user stopped topping up.
+	TopUpTrend float64 `json:"topUpTrend"`
+	// GapTrend is the current gap between actions, the larger of the last
+	// gap and the time since the last action, over the mean gap in the
+	// Window: above 1 the user is slowing down.
+	GapTrend float64 `json:"gapTrend"`
+}
+
+// Score maps features to a churn probability in (0, 1), rounded to four
+// decimals.
+func Score(f Features) float64 {
+	gap := math.Min(math.Max(f.GapTrend, 0.1), 10)
+	z := intercept +
+		recencyWeight*f.RecencyDays +
+		actionsWeight*math.Log1p(float64(f.Actions)) +
+		betsWeight*math.Log1p(float64(f.Bets)) +
+		topUpWeight*(f.TopUpTrend-0.5) +
+		gapWeight*math.Log(gap)
+	p := 1 / (1 + math.Exp(-z))
+	return math.Round(p*10000) / 10000
+}
+
+// Band names the risk band of score.
+func Band(score float64) string {
+	switch {
+	case score >= highFrom:
+		return BandHigh
+	case score >= mediumFrom:
+		return BandMedium
+	}
+	return BandLow
+}
+
+// ParseMinRisk reads a minimum risk given as a score between 0 and 1 or as
+// a band name, which stands for the lowest score of the band.
+func ParseMinRisk(raw string) (float64, error) {
+	switch strings.ToLower(raw) {
+	case BandLow:
+		return 0, nil
+	case BandMedium:
+		return mediumFrom, nil
+	case BandHigh:
+		return highFrom, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 || v > 1 {
+		return 0, fmt.Errorf("%q is neither a score between 0 and 1 nor one of %s, %s, %s", raw, BandLow, BandMedium, BandHigh)
+	}
+	return v, nil
+}
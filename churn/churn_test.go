@@ -0,0 +1,147 @@
+package churn
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name string
+		f    Features
+		want float64
+		band string
+	}{
+		{
+			name: "weekly player",
+			f:    Features{RecencyDays: 3, Actions: 13, Bets: 10, TopUpTrend: 0.5, GapTrend: 0.5},
+			want: 0.0245,
+			band: BandLow,
+		},
+		{
+			name: "silent for a month after a single action",
+			f:    Features{RecencyDays: 30, Actions: 1, TopUpTrend: 0.5, GapTrend: 1},
+			want: 0.4142,
+			band: BandMedium,
+		},
+		{
+			name: "stopped topping up and slowing down",
+			f:    Features{RecencyDays: 30, Actions: 2, Bets: 1, TopUpTrend: 0, GapTrend: 3},
+			want: 0.7184,
+			band: BandHigh,
+		},
+		{
+			name: "nothing in the window",
+			f:    Features{RecencyDays: 80, TopUpTrend: 0.5, GapTrend: 1},
+			want: 0.9241,
+			band: BandHigh,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Score(tt.f)
+			if got != tt.want {
+				t.Errorf("Score = %v, want %v", got, tt.want)
+			}
+			if band := Band(got); band != tt.band {
+				t.Errorf("Band(%v) = %s, want %s", got, band, tt.band)
+			}
+		})
+	}
+}
+
+func TestScoreMonotonic(t *testing.T) {
+	base := Features{RecencyDays: 20, Actions: 5, Bets: 3, TopUpTrend: 0.5, GapTrend: 1}
+
+	tests := []struct {
+		name   string
+		change func(*Features)
+	}{
+		{"older last action", func(f *Features) { f.RecencyDays = 40 }},
+		{"fewer actions", func(f *Features) { f.Actions = 1 }},
+		{"fewer bets", func(f *Features) { f.Bets = 0 }},
+		{"falling top-ups", func(f *Features) { f.TopUpTrend = 0.1 }},
+		{"widening gaps", func(f *Features) { f.GapTrend = 4 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := base
+			tt.change(&f)
+			if Score(f) <= Score(base) {
+				t.Errorf("Score = %v, want above %v", Score(f), Score(base))
+			}
+		})
+	}
+}
+
+func TestScoreClampsGapTrend(t *testing.T) {
+	f := Features{RecencyDays: 10, Actions: 4, TopUpTrend: 0.5}
+
+	tests := []struct {
+		gap, same float64
+	}{
+		{gap: 0, same: 0.1},
+		{gap: 0.01, same: 0.1},
+		{gap: 50, same: 10},
+	}
+	for _, tt := range tests {
+		f.GapTrend = tt.gap
+		got := Score(f)
+		f.GapTrend = tt.same
+		if want := Score(f); got != want {
+			t.Errorf("Score with GapTrend %v = %v, want %v as with %v", tt.gap, got, want, tt.same)
+		}
+	}
+}
+
+func TestBand(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, BandLow},
+		{0.3999, BandLow},
+		{0.4, BandMedium},
+		{0.6999, BandMedium},
+		{0.7, BandHigh},
+		{1, BandHigh},
+	}
+	for _, tt := range tests {
+		if got := Band(tt.score); got != tt.want {
+			t.Errorf("Band(%v) = %s, want %s", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestParseMinRisk(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    float64
+		wantErr bool
+	}{
+		{raw: "low", want: 0},
+		{raw: "medium", want: 0.4},
+		{raw: "HIGH", want: 0.7},
+		{raw: "0", want: 0},
+		{raw: "0.55", want: 0.55},
+		{raw: "1", want: 1},
+		{raw: "", wantErr: true},
+		{raw: "-0.1", wantErr: true},
+		{raw: "1.01", wantErr: true},
+		{raw: "critical", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := ParseMinRisk(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseMinRisk(%q) = %v, want error", tt.raw, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseMinRisk(%q) = %v, %v, want %v", tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+type ConcurrencyConfig struct {
+	Workers               int
+	MaxOpenSearchRequests int
+}
+
+func LoadConcurrencyConfig() (*ConcurrencyConfig, error) {
+	// A missing .env is reported by LoadOpenSearchConfig.
+	_ = godotenv.Load()
+
+	workers, err := intFromEnv("PROCESS_USERS_WORKERS", 16)
+	if err != nil {
+		return nil, err
+	}
+	maxRequests, err := intFromEnv("OPENSEARCH_MAX_CONCURRENT_REQUESTS", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ConcurrencyConfig{
+		Workers:               workers,
+		MaxOpenSearchRequests: maxRequests,
+	}
+
+	log.Printf("info: concurrency config loaded - workers: %d, max OpenSearch requests: %d", workers, maxRequests)
+	return config, nil
+}
+
+func intFromEnv(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadCountryGroups reads named country groups, such as central-asia, from
+// the YAML or JSON file in COUNTRY_GROUPS_FILE: a map of group name to
+// country ids. Without it no groups are defined.
+func LoadCountryGroups() (map[string][]int, error) {
+	// A missing .env is reported by LoadOpenSearchConfig.
+	_ = godotenv.Load()
+
+	path := os.Getenv("COUNTRY_GROUPS_FILE")
+	if path == "" {
+		log.Printf("info: COUNTRY_GROUPS_FILE not set, no country groups defined")
+		return map[string][]int{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read country groups %s: %w", path, err)
+	}
+
+	var groups map[string][]int
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("parse country groups %s: %w", path, err)
+	}
+
+	out := make(map[string][]int, len(groups))
+	for name, ids := range groups {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if key == "" || len(ids) == 0 {
+			return nil, fmt.Errorf("country groups %s: group %q must have a name and at least one country", path, name)
+		}
+		out[key] = ids
+	}
+	log.Printf("info: loaded %d country groups from %s", len(out), path)
+	return out, nil
+}
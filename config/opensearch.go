@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 
+	"action_users/pool"
+
 	"github.com/joho/godotenv"
 	"github.com/opensearch-project/opensearch-go"
 )
@@ -43,7 +45,9 @@ func LoadOpenSearchConfig() (*OpenSearchConfig, error) {
 	return config, nil
 }
 
-func NewOpenSearchClient() (*opensearch.Client, error) {
+// NewOpenSearchClient builds a client whose requests all hold a slot of sem
+// while in flight.
+func NewOpenSearchClient(sem *pool.Semaphore) (*opensearch.Client, error) {
 	config, err := LoadOpenSearchConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load OpenSearch config: %w", err)
@@ -53,6 +57,7 @@ func NewOpenSearchClient() (*opensearch.Client, error) {
 		Addresses: []string{config.Host},
 		Username:  config.Username,
 		Password:  config.Password,
+		Transport: &pool.Transport{Semaphore: sem},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OpenSearch client: %w", err)
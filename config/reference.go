@@ -0,0 +1,35 @@
+package config
+
+import (
+	"log"
+	"os"
+
+	"action_users/reference"
+
+	"github.com/joho/godotenv"
+)
+
+// LoadReference builds the country and currency reference table: the
+// built-in one extended from REFERENCE_FILE when set, with the country
+// groups of COUNTRY_GROUPS_FILE.
+func LoadReference() (*reference.Table, error) {
+	// A missing .env is reported by LoadOpenSearchConfig.
+	_ = godotenv.Load()
+
+	table := reference.Default()
+	if path := os.Getenv("REFERENCE_FILE"); path != "" {
+		var err error
+		table, err = reference.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("info: loaded reference table from %s", path)
+	}
+
+	groups, err := LoadCountryGroups()
+	if err != nil {
+		return nil, err
+	}
+	table.SetGroups(groups)
+	return table, nil
+}
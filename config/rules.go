@@ -0,0 +1,30 @@
+package config
+
+import (
+	"log"
+	"os"
+
+	"action_users/rules"
+
+	"github.com/joho/godotenv"
+)
+
+// LoadRules reads the inactivity rules from RULES_FILE. Without it only the
+// default months rule is available.
+func LoadRules() (*rules.Set, error) {
+	// A missing .env is reported by LoadOpenSearchConfig.
+	_ = godotenv.Load()
+
+	path := os.Getenv("RULES_FILE")
+	if path == "" {
+		log.Printf("info: RULES_FILE not set, only the %s rule is available", rules.DefaultName)
+		return rules.NewSet()
+	}
+
+	set, err := rules.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("info: loaded %d inactivity rules from %s", len(set.List()), path)
+	return set, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	OpenSearchBackend = "opensearch"
+	MemoryBackend     = "memory"
+)
+
+type StoreConfig struct {
+	Backend      string
+	FixturesPath string
+}
+
+// LoadStoreConfig selects where clients and actions are read from. The
+// memory backend serves JSON fixtures instead of a cluster and needs no
+// OpenSearch credentials.
+func LoadStoreConfig() (*StoreConfig, error) {
+	// A missing .env is reported by LoadOpenSearchConfig.
+	_ = godotenv.Load()
+
+	backend := os.Getenv("STORE_BACKEND")
+	if backend == "" {
+		backend = OpenSearchBackend
+	}
+
+	config := &StoreConfig{
+		Backend:      backend,
+		FixturesPath: os.Getenv("STORE_FIXTURES"),
+	}
+
+	switch backend {
+	case OpenSearchBackend:
+	case MemoryBackend:
+		if config.FixturesPath == "" {
+			return nil, fmt.Errorf("STORE_FIXTURES is required for the %s backend", MemoryBackend)
+		}
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+
+	log.Printf("info: store config loaded - backend: %s", backend)
+	return config, nil
+}
@@ -1,5 +1,7 @@
 package constants
 
+import "action_users/models"
+
 var Indices = map[string]string{
 	"client_online_top_ups-searcher":     "entity",
 	"client_withdrawals-searcher":        "withdrawal",
@@ -9,6 +11,15 @@ var Indices = map[string]string{
 	"client_online_withdrawals-searcher": "withdrawal",
 }
 
+var ActionTypes = map[string]models.ActionType{
+	"client_online_top_ups-searcher":     models.ActionTopUp,
+	"client_withdrawals-searcher":        models.ActionWithdrawal,
+	"client_bets-searcher":               models.ActionBet,
+	"cashier_cards-searcher":             models.ActionCashierCard,
+	"terminal_transactions-searcher":     models.ActionTerminalTransaction,
+	"client_online_withdrawals-searcher": models.ActionWithdrawal,
+}
+
 var TopUpIndices = []string{
 	"client_online_top_ups-searcher",
 	"terminal_transactions-searcher",
@@ -24,7 +35,22 @@ var WithdrawalIndices = []string{
 	"client_withdrawals-searcher",
 }
 
-var countries = map[int]string{
+// Currencies are the ISO 4217 codes of the wallet currencyId values.
+var Currencies = map[int]string{
+	1: "TJS",
+	2: "RUB",
+	3: "UZS",
+}
+
+// CountryCurrencies is the reporting currency of the countries that have one.
+var CountryCurrencies = map[int]int{
+	213: 1,
+	181: 2,
+	233: 3,
+}
+
+// Countries names the user.countryId values.
+var Countries = map[int]string{
 	1:   "Afghanistan",
 	2:   "Aland Islands",
 	3:   "Albania",
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"time"
+
+	"action_users/churn"
+	"action_users/constants"
+	"action_users/models"
+)
+
+// churnFeatures derives the churn features of the users in uas as of now.
+// The latest actions in uas give the recency and the last gap; counts and
+// top-up amounts come from the churn ranges of stats. Users without actions
+// get no features.
+func churnFeatures(uas map[string]*models.UserActions, stats map[string]indexStats, now time.Time) map[string]churn.Features {
+	end := now.Unix()
+	userIds := usersWithActions(uas)
+	out := make(map[string]churn.Features, len(userIds))
+
+	allIndices := make([]string, 0, len(constants.Indices))
+	for idx := range constants.Indices {
+		allIndices = append(allIndices, idx)
+	}
+
+	for _, uid := range userIds {
+		ua, st := uas[uid], stats[uid]
+		last := ua.Recent[0].CreatedAt
+
+		var all models.ActionStats
+		all.Add(st.sum(allIndices, rangeChurnPrior))
+		all.Add(st.sum(allIndices, rangeChurnRecent))
+
+		f := churn.Features{
+			RecencyDays: float64(max(end-last, 0)) / secondsPerDay,
+			Actions:     all.Count,
+			Bets:        st.sum(constants.BetIndices, rangeChurnPrior).Count + st.sum(constants.BetIndices, rangeChurnRecent).Count,
+			TopUpTrend:  0.5,
+			GapTrend:    1,
+		}
+
+		prior := st.sum(constants.TopUpIndices, rangeChurnPrior).Amount()
+		recent := st.sum(constants.TopUpIndices, rangeChurnRecent).Amount()
+		if prior+recent > 0 {
+			f.TopUpTrend = recent / (prior + recent)
+		}
+
+		if all.Count >= 2 && all.Last > all.First {
+			mean := float64(all.Last-all.First) / float64(all.Count-1)
+			current := max(end-last, 0)
+			if len(ua.Recent) > 1 {
+				current = max(current, last-ua.Recent[1].CreatedAt)
+			}
+			f.GapTrend = float64(current) / mean
+		}
+		out[uid] = f
+	}
+	return out
+}
+
+// scoreChurn sets the churn score and risk band of cd from f.
+func scoreChurn(cd *models.ClientData, f churn.Features) {
+	cd.ChurnScore = churn.Score(f)
+	cd.RiskBand = churn.Band(cd.ChurnScore)
+}
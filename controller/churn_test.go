@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/churn"
+	"action_users/models"
+	"action_users/rules"
+)
+
+func TestChurnFeaturesGapTrend(t *testing.T) {
+	now := date(2024, time.April, 15)
+
+	tests := []struct {
+		name    string
+		daysAgo []int
+		want    float64
+	}{
+		{name: "steady", daysAgo: []int{80, 60, 40, 20}, want: 1},
+		{name: "silent since a burst", daysAgo: []int{85, 80, 75, 70}, want: 14},
+		{name: "last gap widening", daysAgo: []int{84, 80, 76, 72, 2}, want: 70.0 / 20.5},
+		{name: "speeding up", daysAgo: []int{60, 20, 10, 5}, want: 5.0 / (55.0 / 3)},
+		{name: "single action", daysAgo: []int{30}, want: 1},
+		{name: "same moment", daysAgo: []int{30, 30}, want: 1},
+		{name: "only before the churn window", daysAgo: []int{200, 150}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fx testFixtures
+			for _, d := range tt.daysAgo {
+				fx.bet(1, 213, now.AddDate(0, 0, -d))
+			}
+			c := newTestController(fx.store(), now)
+			uas, err := c.GetLastActionsForUsers([]string{"1"}, models.Countries{}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats, err := c.actionStats([]string{"1"}, pageRanges(now, DefaultMoneyWindow))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, ok := churnFeatures(uas, stats, now)["1"]
+			if !ok {
+				t.Fatal("no features for a user with actions")
+			}
+			if diff := f.GapTrend - tt.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("GapTrend = %v, want %v", f.GapTrend, tt.want)
+			}
+			if want := float64(tt.daysAgo[len(tt.daysAgo)-1]); f.RecencyDays != want {
+				t.Errorf("RecencyDays = %v, want %v", f.RecencyDays, want)
+			}
+		})
+	}
+}
+
+func TestClassifyUsersMinRisk(t *testing.T) {
+	now := date(2024, time.April, 15)
+	betsAgo := map[int][]int{
+		1: {30, 25, 20, 15, 10, 5, 2}, // active, betting steadily
+		2: {70, 60, 50, 40},           // active, gone quiet
+		3: {150, 120, 100},            // inactive
+	}
+	var f testFixtures
+	for uid, days := range betsAgo {
+		for _, d := range days {
+			f.bet(uid, 213, now.AddDate(0, 0, -d))
+		}
+	}
+	f.client(1, 213).client(2, 213).client(3, 213).client(4, 213)
+	c := newTestController(f.store(), now)
+	userIds := []string{"1", "2", "3", "4"}
+
+	scores := map[string]float64{}
+	uas, err := c.GetLastActionsForUsers(userIds, models.Countries{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.actionStats(usersWithActions(uas), pageRanges(now, DefaultMoneyWindow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for uid, f := range churnFeatures(uas, stats, now) {
+		scores[uid] = churn.Score(f)
+	}
+	if !(scores["1"] < 0.4 && scores["2"] >= 0.4 && scores["2"] < 0.7 && scores["3"] < 0.99) {
+		t.Fatalf("scores %v do not fit the cases below", scores)
+	}
+
+	risk := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		minRisk *float64
+		atRisk  []string
+	}{
+		{name: "no minRisk", minRisk: nil, atRisk: []string{}},
+		{name: "everyone active", minRisk: risk(0), atRisk: []string{"1", "2"}},
+		{name: "medium band", minRisk: risk(0.4), atRisk: []string{"2"}},
+		{name: "above every score", minRisk: risk(0.99), atRisk: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, err := c.ClassifyUsers(userIds, ClassifyOptions{
+				Months:  2,
+				Rule:    rules.Default(2, rules.ModeSinceLast),
+				MinRisk: tt.minRisk,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := userIdsOf(segments.AtRisk); !slices.Equal(got, tt.atRisk) {
+				t.Errorf("atRisk = %v, want %v", got, tt.atRisk)
+			}
+			// minRisk only selects the active users to report; the other
+			// segments are the same whatever the threshold.
+			if got := userIdsOf(segments.Inactive); !slices.Equal(got, []string{"3"}) {
+				t.Errorf("inactive = %v, want [3]", got)
+			}
+			if got := userIdsOf(segments.RegisteredNoActions); !slices.Equal(got, []string{"4"}) {
+				t.Errorf("registeredNoActions = %v, want [4]", got)
+			}
+			for _, cd := range segments.AtRisk {
+				if cd.Segment != "atRisk" || cd.RiskBand != churn.Band(scores[cd.UserId]) {
+					t.Errorf("at risk user %s: segment %s, band %s", cd.UserId, cd.Segment, cd.RiskBand)
+				}
+			}
+		})
+	}
+}
@@ -3,44 +3,69 @@ package controller
 import (
 	"fmt"
 	"log"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 
+	"action_users/clock"
 	"action_users/constants"
 	"action_users/models"
+	"action_users/reference"
 	"action_users/repositories"
-
-	"github.com/opensearch-project/opensearch-go"
+	"action_users/rules"
 )
 
 type Controller struct {
-	client *opensearch.Client
+	store   repositories.Store
+	workers int
+	rules   *rules.Set
+	ref     *reference.Table
+	clock   clock.Clock
+
+	rfm rfmCache
+}
+
+// NewController wires the controller to store. workers caps how many users
+// of a page are classified concurrently, ruleSet holds the inactivity rules
+// selectable besides the default one, ref the countries, currencies and
+// country groups, and clk is what "now" means to them.
+func NewController(store repositories.Store, workers int, ruleSet *rules.Set, ref *reference.Table, clk clock.Clock) *Controller {
+	return &Controller{store: store, workers: workers, rules: ruleSet, ref: ref, clock: clk}
+}
+
+// Now is the current time as seen by the controller's clock.
+func (c *Controller) Now() time.Time {
+	return c.clock.Now()
+}
+
+func (c *Controller) GetUserIds(from, size int, filter models.ClientFilter) ([]string, error) {
+	return c.store.GetUserIds(from, size, filter)
 }
 
-func NewController(client *opensearch.Client) *Controller {
-	return &Controller{client: client}
+func (c *Controller) GetUserIdsAfter(cursor models.Cursor, size int, filter models.ClientFilter) ([]string, *models.Cursor, error) {
+	return c.store.GetUserIdsAfter(cursor, size, filter)
 }
 
-func (c *Controller) Client() *opensearch.Client {
-	return c.client
+func (c *Controller) GetClientById(userId string, countries models.Countries) (map[string]interface{}, error) {
+	return c.store.GetClientById(userId, countries)
 }
 
-func (c *Controller) GetLastTwoActionsForUser(userId string, countryId int) ([]map[string]interface{}, error) {
+func (c *Controller) GetLastTwoActionsForUser(userId string, countries models.Countries, until int64) ([]models.Action, error) {
 	var mu sync.Mutex
 	var wg sync.WaitGroup
-	all := []map[string]interface{}{}
+	all := []models.Action{}
 
 	for idx := range constants.Indices {
 		wg.Add(1)
 		go func(index string) {
 			defer wg.Done()
-			var acts []map[string]interface{}
+			var acts []models.Action
 			var err error
 
-			acts, err = repositories.GetActionsFromIndex(c.client, userId, index, 2, countryId)
+			acts, err = c.store.GetActionsFromIndex(userId, index, 2, countries, until)
 			if err != nil || len(acts) == 0 {
-				acts, err = repositories.GetActionsFromIndexNoCountry(c.client, userId, index, 2)
+				acts, err = c.store.GetActionsFromIndexNoCountry(userId, index, 2, until)
 			}
 
 			if err != nil {
@@ -61,7 +86,7 @@ func (c *Controller) GetLastTwoActionsForUser(userId string, countryId int) ([]m
 		return nil, nil
 	}
 	sort.Slice(all, func(i, j int) bool {
-		return repositories.GetCreatedAt(all[i]) > repositories.GetCreatedAt(all[j])
+		return all[i].CreatedAt > all[j].CreatedAt
 	})
 	if len(all) > 2 {
 		all = all[:2]
@@ -69,17 +94,85 @@ func (c *Controller) GetLastTwoActionsForUser(userId string, countryId int) ([]m
 	return all, nil
 }
 
-func (c *Controller) GetLastActionFromIndices(userId string, indicesList []string, countryId int) (map[string]interface{}, error) {
-	var best map[string]interface{}
-	var bestTs int64
+// GetLastActionsForUsers batches what GetLastTwoActionsForUser and
+// GetLastActionFromIndices do per user into one lookup for a whole page.
+// Users without any action are absent from the result. Actions created
+// after until are ignored unless until is 0.
+func (c *Controller) GetLastActionsForUsers(userIds []string, countries models.Countries, until int64) (map[string]*models.UserActions, error) {
+	byUser, err := c.lastActionsByIndex(userIds, countries, until)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]*models.UserActions, len(byUser))
+	for uid, byIndex := range byUser {
+		if ua := userActionsFromIndices(byIndex); ua != nil {
+			out[uid] = ua
+		}
+	}
+	return out, nil
+}
+
+// lastActionsByIndex reads the two latest actions of each user from every
+// action index, retrying an index without the country filter for the users
+// it had nothing for.
+func (c *Controller) lastActionsByIndex(userIds []string, countries models.Countries, until int64) (map[string]map[string][]models.Action, error) {
+	indicesList := make([]string, 0, len(constants.Indices))
+	for idx := range constants.Indices {
+		indicesList = append(indicesList, idx)
+	}
+	return c.store.GetLastActionsForUsers(userIds, indicesList, 2, countries, until)
+}
 
+// userActionsFromIndices reduces the latest actions of one user per index to
+// the two most recent overall and the latest of each action group; nil when
+// there are none.
+func userActionsFromIndices(byIndex map[string][]models.Action) *models.UserActions {
+	ua := &models.UserActions{
+		TopUp:      latestFromIndices(byIndex, constants.TopUpIndices),
+		Bet:        latestFromIndices(byIndex, constants.BetIndices),
+		Withdrawal: latestFromIndices(byIndex, constants.WithdrawalIndices),
+	}
+	for _, acts := range byIndex {
+		ua.Recent = append(ua.Recent, acts...)
+	}
+	if len(ua.Recent) == 0 {
+		return nil
+	}
+	sort.Slice(ua.Recent, func(i, j int) bool {
+		return ua.Recent[i].CreatedAt > ua.Recent[j].CreatedAt
+	})
+	if len(ua.Recent) > 2 {
+		ua.Recent = ua.Recent[:2]
+	}
+	return ua
+}
+
+func latestFromIndices(byIndex map[string][]models.Action, indicesList []string) *models.Action {
+	var best *models.Action
 	for _, idx := range indicesList {
-		var srcs []map[string]interface{}
+		acts := byIndex[idx]
+		if len(acts) == 0 {
+			continue
+		}
+		if best == nil || acts[0].CreatedAt > best.CreatedAt {
+			act := acts[0]
+			best = &act
+		}
+	}
+	return best
+}
+
+func (c *Controller) GetLastActionFromIndices(userId string, indicesList []string, countries models.Countries, until int64) (*models.Action, error) {
+	var best *models.Action
+
+	for _, idx := range indicesList {
+		var srcs []models.Action
 		var err error
 
-		srcs, err = repositories.GetActionsFromIndex(c.client, userId, idx, 1, countryId)
+		srcs, err = c.store.GetActionsFromIndex(userId, idx, 1, countries, until)
 		if err != nil || len(srcs) == 0 {
-			srcs, err = repositories.GetActionsFromIndexNoCountry(c.client, userId, idx, 1)
+			srcs, err = c.store.GetActionsFromIndexNoCountry(userId, idx, 1, until)
 		}
 
 		if err != nil {
@@ -89,57 +182,34 @@ func (c *Controller) GetLastActionFromIndices(userId string, indicesList []strin
 		if len(srcs) == 0 {
 			continue
 		}
-		ts := repositories.GetCreatedAt(srcs[0])
-		if ts > bestTs {
-			bestTs = ts
-			best = srcs[0]
+		if best == nil || srcs[0].CreatedAt > best.CreatedAt {
+			best = &srcs[0]
 		}
 	}
 	return best, nil
 }
 
-func (c *Controller) CheckUserActionsInterval(actions []map[string]interface{}, frontInterval int) bool {
-	if len(actions) == 0 {
-		return false
-	}
-	first := repositories.GetCreatedAt(actions[0])
-	if first == 0 {
-		return false
-	}
-
-	var compareTime time.Time
-	if len(actions) == 1 {
-		compareTime = time.Now()
-	} else {
-		second := repositories.GetCreatedAt(actions[1])
-		if second == 0 {
-			compareTime = time.Now()
-		} else {
-			compareTime = time.Unix(second, 0)
-		}
-	}
-
-	firstTime := time.Unix(first, 0)
-	if firstTime.Before(compareTime) {
-		firstTime, compareTime = compareTime, firstTime
-	}
-	diffMonths := int(firstTime.Sub(compareTime).Hours() / 24 / 30)
-	return diffMonths >= frontInterval
+// CheckUserActionsInterval reports whether the two latest actions, or the
+// only one and now, are at least frontInterval calendar months apart.
+func (c *Controller) CheckUserActionsInterval(actions []models.Action, frontInterval int) bool {
+	return rules.Default(frontInterval, rules.ModeGap).Matches(&models.UserActions{Recent: actions}, c.Now())
 }
 
+// GetReactivationThreshold moves lastActionTime back by months calendar
+// months; March 31 minus one month is the last day of February.
 func (c *Controller) GetReactivationThreshold(lastActionTime time.Time, months int) time.Time {
-	thresholdDate := lastActionTime.AddDate(0, -months, 0)
+	thresholdDate := rules.MonthsBefore(lastActionTime, months)
 	log.Printf("debug: last action %s - %d months = threshold %s",
 		lastActionTime.Format("2006-01-02"), months, thresholdDate.Format("2006-01-02"))
 	return thresholdDate
 }
 
-func (c *Controller) GetLastActionDate(actions []map[string]interface{}) (time.Time, bool) {
+func (c *Controller) GetLastActionDate(actions []models.Action) (time.Time, bool) {
 	if len(actions) == 0 {
 		return time.Time{}, false
 	}
 
-	lastActionTimestamp := repositories.GetCreatedAt(actions[0])
+	lastActionTimestamp := actions[0].CreatedAt
 	if lastActionTimestamp == 0 {
 		return time.Time{}, false
 	}
@@ -147,17 +217,91 @@ func (c *Controller) GetLastActionDate(actions []map[string]interface{}) (time.T
 	return time.Unix(lastActionTimestamp, 0), true
 }
 
-func (c *Controller) BuildClientData(clientData map[string]interface{}, topUpSrc, betSrc, withdrawalSrc map[string]interface{}, frontCountryId int, userId string, actions []map[string]interface{}, months int) models.ClientData {
+// wallets reads every wallet of a clients-searcher document. Only the first
+// wallet with isActive == 1 is flagged active.
+func (c *Controller) wallets(clientData map[string]interface{}) []models.Wallet {
+	raw, _ := clientData["wallets"].([]interface{})
+	out := make([]models.Wallet, 0, len(raw))
+	hasActive := false
+	for _, w := range raw {
+		wallet, ok := w.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var mw models.Wallet
+		switch v := wallet["no"].(type) {
+		case string:
+			mw.Number = v
+		case float64:
+			mw.Number = fmt.Sprintf("%.0f", v)
+		}
+		if balance, ok := wallet["balance"].(float64); ok {
+			mw.Balance = balance
+		}
+		if currencyId, ok := wallet["currencyId"].(float64); ok {
+			mw.CurrencyId = int(currencyId)
+			mw.CurrencyCode = c.ref.CurrencyCode(mw.CurrencyId)
+		}
+		if active, ok := wallet["isActive"].(float64); ok && active == 1 && !hasActive {
+			mw.Active = true
+			hasActive = true
+		}
+		out = append(out, mw)
+	}
+	return out
+}
+
+// totalBalance sums the wallets of a into the reporting currency, when one
+// is configured.
+func (c *Controller) totalBalance(a *models.Account) {
+	reporting, ok := c.ref.ReportingCurrency()
+	if !ok {
+		return
+	}
+	a.TotalCurrency = reporting.Code
+	var total float64
+	for _, w := range a.Wallets {
+		v, ok := c.ref.Convert(w.Balance, w.CurrencyId)
+		if !ok {
+			if !slices.Contains(a.Unconverted, w.CurrencyId) {
+				a.Unconverted = append(a.Unconverted, w.CurrencyId)
+			}
+			continue
+		}
+		total += v
+	}
+	slices.Sort(a.Unconverted)
+	a.TotalBalance = round2(total)
+}
+
+// registeredAt reads the registration time, user.createdAt, of a
+// clients-searcher document.
+func registeredAt(clientData map[string]interface{}) (int64, bool) {
+	user, ok := clientData["user"].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	createdAt, ok := user["createdAt"].(float64)
+	if !ok || createdAt <= 0 {
+		return 0, false
+	}
+	return int64(createdAt), true
+}
+
+// BuildClientData assembles the response row of one user. CanReactivate is
+// decided as of asOf, or the current time when it is zero.
+func (c *Controller) BuildClientData(clientData map[string]interface{}, topUp, bet, withdrawal *models.Action, frontCountryId int, userId string, actions []models.Action, months int, asOf time.Time) models.ClientData {
 	cd := models.ClientData{
-		Account:               models.Account{ActiveWallet: "", Balance: 0, CurrencyId: 0},
-		LastTopUp:             repositories.GetCreatedAt(topUpSrc),
-		LastBet:               repositories.GetCreatedAt(betSrc),
-		LastWithdrawal:        repositories.GetCreatedAt(withdrawalSrc),
+		Account:               models.Account{Wallets: []models.Wallet{}, CurrencyId: 0},
+		LastTopUp:             createdAt(topUp),
+		LastBet:               createdAt(bet),
+		LastWithdrawal:        createdAt(withdrawal),
 		CreatedAt:             0,
 		UserId:                userId,
 		LastActivity:          0,
 		ReactivationThreshold: 0,
 		CanReactivate:         false,
+		Actions:               mergeActions(actions, topUp, bet, withdrawal),
 	}
 
 	var maxActivity int64
@@ -192,7 +336,10 @@ func (c *Controller) BuildClientData(clientData map[string]interface{}, topUpSrc
 			thresholdDate := c.GetReactivationThreshold(lastActionDate, months)
 			cd.ReactivationThreshold = thresholdDate.Unix()
 
-			currentTime := time.Now()
+			currentTime := asOf
+			if currentTime.IsZero() {
+				currentTime = c.Now()
+			}
 			cd.CanReactivate = currentTime.After(thresholdDate) || currentTime.Equal(thresholdDate)
 
 			log.Printf("debug: user %s - lastActivity: %s, threshold: %s, canReactivate: %t (months=%d)",
@@ -203,8 +350,8 @@ func (c *Controller) BuildClientData(clientData map[string]interface{}, topUpSrc
 
 	if clientData != nil {
 		if user, ok := clientData["user"].(map[string]interface{}); ok {
-			if createdAt, ok := user["createdAt"].(float64); ok && createdAt > 0 {
-				cd.CreatedAt = int64(createdAt)
+			if createdAt, ok := registeredAt(clientData); ok {
+				cd.CreatedAt = createdAt
 			} else if cd.CreatedAt == 0 && maxActivity > 0 {
 				var earliestTs int64
 				if cd.LastTopUp > 0 && (earliestTs == 0 || cd.LastTopUp < earliestTs) {
@@ -248,26 +395,9 @@ func (c *Controller) BuildClientData(clientData map[string]interface{}, topUpSrc
 			}
 		}
 
-		if wallets, ok := clientData["wallets"].([]interface{}); ok {
-			for _, w := range wallets {
-				if wallet, ok := w.(map[string]interface{}); ok {
-					if active, ok := wallet["isActive"].(float64); ok && active == 1 {
-						switch v := wallet["no"].(type) {
-						case string:
-							cd.Account.ActiveWallet = v
-						case float64:
-							cd.Account.ActiveWallet = fmt.Sprintf("%.0f", v)
-						}
-						if balance, ok := wallet["balance"].(float64); ok {
-							cd.Account.Balance = balance
-						}
-						if currencyId, ok := wallet["currencyId"].(float64); ok {
-							cd.Account.CurrencyId = int(currencyId)
-						}
-						break
-					}
-				}
-			}
+		cd.Account.Wallets = c.wallets(clientData)
+		if active, ok := cd.Account.ActiveWallet(); ok {
+			cd.Account.CurrencyId = active.CurrencyId
 		}
 	} else {
 		cd.CountryId = frontCountryId
@@ -292,14 +422,12 @@ func (c *Controller) BuildClientData(clientData map[string]interface{}, topUpSrc
 		}
 	}
 
-	switch frontCountryId {
-	case 213:
-		cd.Account.CurrencyId = 1
-	case 181:
-		cd.Account.CurrencyId = 2
-	case 233:
-		cd.Account.CurrencyId = 3
+	if currencyId, ok := c.ref.CountryCurrency(frontCountryId); ok {
+		cd.Account.CurrencyId = currencyId
 	}
+	cd.CountryName = c.ref.CountryName(cd.CountryId)
+	cd.CurrencyCode = c.ref.CurrencyCode(cd.Account.CurrencyId)
+	c.totalBalance(&cd.Account)
 
 	if cd.LastTopUp == 0 && cd.LastBet == 0 && cd.LastWithdrawal == 0 {
 		cd.LastActivity = 0
@@ -308,3 +436,38 @@ func (c *Controller) BuildClientData(clientData map[string]interface{}, topUpSrc
 	return cd
 
 }
+
+func createdAt(action *models.Action) int64 {
+	if action == nil {
+		return 0
+	}
+	return action.CreatedAt
+}
+
+// mergeActions lists every action the classification looked at once, newest
+// first.
+func mergeActions(recent []models.Action, latest ...*models.Action) []models.Action {
+	out := []models.Action{}
+	seen := map[string]bool{}
+	add := func(a models.Action) {
+		key := fmt.Sprintf("%s/%s/%d", a.Index, a.Id, a.CreatedAt)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		out = append(out, a)
+	}
+
+	for _, a := range recent {
+		add(a)
+	}
+	for _, a := range latest {
+		if a != nil {
+			add(*a)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].CreatedAt > out[j].CreatedAt
+	})
+	return out
+}
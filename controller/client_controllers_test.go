@@ -0,0 +1,466 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/models"
+)
+
+func TestCheckUserActionsInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		now     time.Time
+		actions []models.Action
+		months  int
+		want    bool
+	}{
+		{
+			name:   "no actions",
+			now:    date(2024, time.March, 31),
+			months: 1,
+			want:   false,
+		},
+		{
+			name:    "zero timestamp",
+			now:     date(2024, time.March, 31),
+			actions: []models.Action{{CreatedAt: 0}},
+			months:  1,
+			want:    false,
+		},
+		{
+			name:    "zero second timestamp falls back to now",
+			now:     date(2024, time.March, 31),
+			actions: []models.Action{{CreatedAt: ts(2024, time.February, 29)}, {CreatedAt: 0}},
+			months:  1,
+			want:    true,
+		},
+		{
+			name:    "single action one month back from March 31 in a leap year",
+			now:     date(2024, time.March, 31),
+			actions: []models.Action{{CreatedAt: ts(2024, time.February, 29)}},
+			months:  1,
+			want:    true,
+		},
+		{
+			name:    "single action inside the month",
+			now:     date(2024, time.March, 31),
+			actions: []models.Action{{CreatedAt: ts(2024, time.March, 1)}},
+			months:  1,
+			want:    false,
+		},
+		{
+			name:    "single action one month back from March 31 in a common year",
+			now:     date(2023, time.March, 31),
+			actions: []models.Action{{CreatedAt: ts(2023, time.February, 28)}},
+			months:  1,
+			want:    true,
+		},
+		{
+			name: "end of January to end of February is short of a month",
+			now:  date(2023, time.June, 1),
+			actions: []models.Action{
+				{CreatedAt: ts(2023, time.February, 28)},
+				{CreatedAt: ts(2023, time.January, 31)},
+			},
+			months: 1,
+			want:   false,
+		},
+		{
+			name: "same day of consecutive months",
+			now:  date(2023, time.June, 1),
+			actions: []models.Action{
+				{CreatedAt: ts(2023, time.February, 28)},
+				{CreatedAt: ts(2023, time.January, 28)},
+			},
+			months: 1,
+			want:   true,
+		},
+		{
+			name: "across a year boundary",
+			now:  date(2024, time.June, 1),
+			actions: []models.Action{
+				{CreatedAt: ts(2024, time.January, 15)},
+				{CreatedAt: ts(2023, time.December, 15)},
+			},
+			months: 1,
+			want:   true,
+		},
+		{
+			name: "leap day to February 28 is short of a year",
+			now:  date(2025, time.June, 1),
+			actions: []models.Action{
+				{CreatedAt: ts(2025, time.February, 28)},
+				{CreatedAt: ts(2024, time.February, 29)},
+			},
+			months: 12,
+			want:   false,
+		},
+		{
+			name: "February 28 to leap day is a full year",
+			now:  date(2024, time.June, 1),
+			actions: []models.Action{
+				{CreatedAt: ts(2024, time.February, 29)},
+				{CreatedAt: ts(2023, time.February, 28)},
+			},
+			months: 12,
+			want:   true,
+		},
+		{
+			name: "actions out of order",
+			now:  date(2024, time.June, 1),
+			actions: []models.Action{
+				{CreatedAt: ts(2024, time.January, 1)},
+				{CreatedAt: ts(2024, time.May, 1)},
+			},
+			months: 3,
+			want:   true,
+		},
+		{
+			name:    "zero months",
+			now:     date(2024, time.March, 31),
+			actions: []models.Action{{CreatedAt: ts(2024, time.March, 31)}},
+			months:  0,
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(nil, tt.now)
+			if got := c.CheckUserActionsInterval(tt.actions, tt.months); got != tt.want {
+				t.Errorf("CheckUserActionsInterval() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetReactivationThreshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		lastAction time.Time
+		months     int
+		want       time.Time
+	}{
+		{"zero months", date(2024, time.May, 15), 0, date(2024, time.May, 15)},
+		{"mid month", date(2024, time.May, 15), 1, date(2024, time.April, 15)},
+		{"across a year boundary", date(2024, time.January, 15), 1, date(2023, time.December, 15)},
+		{"March 31 in a leap year", date(2024, time.March, 31), 1, date(2024, time.February, 29)},
+		{"March 31 in a common year", date(2023, time.March, 31), 1, date(2023, time.February, 28)},
+		{"July 31 into a 30-day month", date(2024, time.July, 31), 1, date(2024, time.June, 30)},
+		{"May 31 back to a leap February", date(2024, time.May, 31), 3, date(2024, time.February, 29)},
+		{"leap day back a year", date(2024, time.February, 29), 12, date(2023, time.February, 28)},
+		{"leap day back four years", date(2024, time.February, 29), 48, date(2020, time.February, 29)},
+		{"back over several years", date(2024, time.March, 1), 25, date(2022, time.February, 1)},
+	}
+
+	c := newTestController(nil, date(2024, time.June, 1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.GetReactivationThreshold(tt.lastAction, tt.months); !got.Equal(tt.want) {
+				t.Errorf("GetReactivationThreshold() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildClientData(t *testing.T) {
+	topUp := &models.Action{Type: models.ActionTopUp, Index: "transactions-topup", Id: "t1", CreatedAt: ts(2024, time.March, 31)}
+	bet := &models.Action{Type: models.ActionBet, Index: "bets", Id: "b1", CreatedAt: ts(2024, time.January, 10)}
+	client := map[string]interface{}{
+		"user": map[string]interface{}{
+			"createdAt": float64(ts(2023, time.June, 1)),
+			"login":     "client",
+			"countryId": float64(213),
+			"state":     float64(1),
+		},
+		"stats": map[string]interface{}{"platform": float64(2)},
+		"wallets": []interface{}{
+			map[string]interface{}{"no": "W-1", "isActive": float64(0), "balance": float64(5), "currencyId": float64(3)},
+			map[string]interface{}{"no": float64(42), "isActive": float64(1), "balance": float64(12.5), "currencyId": float64(1)},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		now            time.Time
+		client         map[string]interface{}
+		topUp, bet     *models.Action
+		countryId      int
+		actions        []models.Action
+		months         int
+		asOf           time.Time
+		wantActivity   int64
+		wantThreshold  int64
+		wantReactivate bool
+		wantCreatedAt  int64
+		wantCurrency   int
+	}{
+		{
+			name:           "client with wallets, threshold on leap day",
+			now:            date(2024, time.April, 15),
+			client:         client,
+			topUp:          topUp,
+			bet:            bet,
+			countryId:      213,
+			actions:        []models.Action{*topUp, *bet},
+			months:         1,
+			wantActivity:   ts(2024, time.March, 31),
+			wantThreshold:  ts(2024, time.February, 29),
+			wantReactivate: true,
+			wantCreatedAt:  ts(2023, time.June, 1),
+			wantCurrency:   1,
+		},
+		{
+			name:           "now exactly at the threshold",
+			now:            date(2024, time.February, 29),
+			client:         client,
+			topUp:          topUp,
+			countryId:      0,
+			actions:        []models.Action{*topUp},
+			months:         1,
+			wantActivity:   ts(2024, time.March, 31),
+			wantThreshold:  ts(2024, time.February, 29),
+			wantReactivate: true,
+			wantCreatedAt:  ts(2023, time.June, 1),
+			wantCurrency:   1,
+		},
+		{
+			name:           "asOf before the threshold wins over the clock",
+			now:            date(2024, time.April, 15),
+			client:         client,
+			topUp:          topUp,
+			countryId:      0,
+			actions:        []models.Action{*topUp},
+			months:         1,
+			asOf:           date(2024, time.February, 28),
+			wantActivity:   ts(2024, time.March, 31),
+			wantThreshold:  ts(2024, time.February, 29),
+			wantReactivate: false,
+			wantCreatedAt:  ts(2023, time.June, 1),
+			wantCurrency:   1,
+		},
+		{
+			name:           "orphan takes created at from its first action",
+			now:            date(2024, time.April, 15),
+			topUp:          topUp,
+			bet:            bet,
+			countryId:      233,
+			actions:        []models.Action{*topUp, *bet},
+			months:         2,
+			wantActivity:   ts(2024, time.March, 31),
+			wantThreshold:  ts(2024, time.January, 31),
+			wantReactivate: true,
+			wantCreatedAt:  ts(2024, time.January, 10),
+			wantCurrency:   3,
+		},
+		{
+			name:           "orphan without a known country",
+			now:            date(2024, time.April, 15),
+			bet:            bet,
+			countryId:      0,
+			actions:        []models.Action{*bet},
+			months:         1,
+			wantActivity:   ts(2024, time.January, 10),
+			wantThreshold:  ts(2023, time.December, 10),
+			wantReactivate: true,
+			wantCreatedAt:  ts(2024, time.January, 10),
+			wantCurrency:   1,
+		},
+		{
+			name:          "zero timestamps",
+			now:           date(2024, time.April, 15),
+			client:        client,
+			topUp:         &models.Action{Type: models.ActionTopUp},
+			countryId:     181,
+			actions:       []models.Action{{Type: models.ActionTopUp}},
+			months:        1,
+			wantCreatedAt: ts(2023, time.June, 1),
+			wantCurrency:  2,
+		},
+		{
+			name:          "no actions",
+			now:           date(2024, time.April, 15),
+			client:        client,
+			countryId:     0,
+			months:        1,
+			wantCreatedAt: ts(2023, time.June, 1),
+			wantCurrency:  1,
+		},
+		{
+			name:          "zero months skips the threshold",
+			now:           date(2024, time.April, 15),
+			client:        client,
+			topUp:         topUp,
+			countryId:     0,
+			actions:       []models.Action{*topUp},
+			months:        0,
+			wantActivity:  ts(2024, time.March, 31),
+			wantCreatedAt: ts(2023, time.June, 1),
+			wantCurrency:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(nil, tt.now)
+			cd := c.BuildClientData(tt.client, tt.topUp, tt.bet, nil, tt.countryId, "1001", tt.actions, tt.months, tt.asOf)
+
+			if cd.LastActivity != tt.wantActivity {
+				t.Errorf("LastActivity = %d, want %d", cd.LastActivity, tt.wantActivity)
+			}
+			if cd.ReactivationThreshold != tt.wantThreshold {
+				t.Errorf("ReactivationThreshold = %d, want %d", cd.ReactivationThreshold, tt.wantThreshold)
+			}
+			if cd.CanReactivate != tt.wantReactivate {
+				t.Errorf("CanReactivate = %t, want %t", cd.CanReactivate, tt.wantReactivate)
+			}
+			if cd.CreatedAt != tt.wantCreatedAt {
+				t.Errorf("CreatedAt = %d, want %d", cd.CreatedAt, tt.wantCreatedAt)
+			}
+			if cd.Account.CurrencyId != tt.wantCurrency {
+				t.Errorf("Account.CurrencyId = %d, want %d", cd.Account.CurrencyId, tt.wantCurrency)
+			}
+			if cd.UserId != "1001" {
+				t.Errorf("UserId = %q, want %q", cd.UserId, "1001")
+			}
+		})
+	}
+}
+
+func TestBuildClientDataClientFields(t *testing.T) {
+	c := newTestController(nil, date(2024, time.April, 15))
+	client := map[string]interface{}{
+		"user": map[string]interface{}{
+			"login":     "client",
+			"firstName": "First",
+			"lastName":  "Last",
+			"phone":     "+992000000000",
+			"countryId": float64(213),
+			"state":     float64(1),
+		},
+		"stats": map[string]interface{}{"platform": float64(2)},
+		"wallets": []interface{}{
+			map[string]interface{}{"no": "W-1", "isActive": float64(0), "balance": float64(5), "currencyId": float64(3)},
+			map[string]interface{}{"no": float64(42), "isActive": float64(1), "balance": float64(12.5), "currencyId": float64(1)},
+		},
+	}
+	bet := &models.Action{Type: models.ActionBet, CreatedAt: ts(2024, time.January, 10)}
+	withdrawal := &models.Action{Type: models.ActionWithdrawal, CreatedAt: ts(2024, time.February, 29)}
+
+	cd := c.BuildClientData(client, nil, bet, withdrawal, 0, "1001", []models.Action{*withdrawal, *bet}, 1, time.Time{})
+
+	if cd.Login != "client" || cd.FirstName != "First" || cd.LastName != "Last" || cd.Phone != "+992000000000" {
+		t.Errorf("user fields = %q %q %q %q", cd.Login, cd.FirstName, cd.LastName, cd.Phone)
+	}
+	if cd.CountryId != 213 || cd.State != 1 || cd.Platform != 2 {
+		t.Errorf("countryId, state, platform = %d, %d, %d, want 213, 1, 2", cd.CountryId, cd.State, cd.Platform)
+	}
+	if cd.CountryName != "Tajikistan" || cd.CurrencyCode != "TJS" {
+		t.Errorf("countryName, currencyCode = %q, %q, want Tajikistan, TJS", cd.CountryName, cd.CurrencyCode)
+	}
+	active, ok := cd.Account.ActiveWallet()
+	if !ok || active.Number != "42" || active.Balance != 12.5 || cd.Account.CurrencyId != 1 {
+		t.Errorf("Account = %+v, want active wallet 42 with 12.5 in currency 1", cd.Account)
+	}
+	if len(cd.Account.Wallets) != 2 || cd.Account.Wallets[0].Number != "W-1" || cd.Account.Wallets[0].Active {
+		t.Errorf("Wallets = %+v, want the inactive wallet W-1 kept", cd.Account.Wallets)
+	}
+	if cd.Account.TotalCurrency != "" || cd.Account.TotalBalance != 0 {
+		t.Errorf("total balance = %v %q without a reporting currency, want none", cd.Account.TotalBalance, cd.Account.TotalCurrency)
+	}
+	if cd.CreatedAt != ts(2024, time.January, 10) {
+		t.Errorf("CreatedAt = %d, want the first action %d", cd.CreatedAt, ts(2024, time.January, 10))
+	}
+	if cd.LastActivity != ts(2024, time.February, 29) {
+		t.Errorf("LastActivity = %d, want %d", cd.LastActivity, ts(2024, time.February, 29))
+	}
+	if len(cd.Actions) != 2 {
+		t.Errorf("len(Actions) = %d, want 2", len(cd.Actions))
+	}
+}
+
+func TestGetLastActionsForUsersFixtures(t *testing.T) {
+	ctrl := newTestController(loadFixtures(t), date(2026, time.October, 17))
+
+	got, err := ctrl.GetLastActionsForUsers([]string{"1001", "1002", "1003", "1004", "2001"}, models.Countries{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["1003"]; ok {
+		t.Errorf("1003 has no actions but is in the result")
+	}
+
+	ua := got["1001"]
+	if ua == nil {
+		t.Fatal("1001 missing")
+	}
+	var recent []int64
+	for _, act := range ua.Recent {
+		recent = append(recent, act.CreatedAt)
+	}
+	if !slices.Equal(recent, []int64{1736467200, 1733011200}) {
+		t.Errorf("1001 recent = %v, want the bet then the top-up", recent)
+	}
+	if ua.TopUp == nil || ua.Bet == nil || ua.Withdrawal != nil {
+		t.Errorf("1001 sources = %+v, want top-up and bet only", ua)
+	}
+
+	if ua := got["2001"]; ua == nil || ua.TopUp == nil || ua.Withdrawal == nil || ua.Bet != nil {
+		t.Errorf("2001 = %+v, want top-up and withdrawal only", ua)
+	}
+	if ua := got["1004"]; ua == nil || len(ua.Recent) != 1 {
+		t.Errorf("1004 = %+v, want its one terminal transaction", ua)
+	}
+
+	// Until 2025-01-01 1001 has only its top-up.
+	got, err = ctrl.GetLastActionsForUsers([]string{"1001"}, models.Countries{}, 1735689600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ua := got["1001"]; ua == nil || len(ua.Recent) != 1 || ua.Bet != nil || ua.TopUp == nil {
+		t.Errorf("1001 until 2025-01-01 = %+v, want its top-up only", ua)
+	}
+
+	// 1002 acts from 233 only, so the 213 lookup falls back to any country.
+	got, err = ctrl.GetLastActionsForUsers([]string{"1001", "1002"}, models.SingleCountry(213), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["1001"] == nil || got["1002"] == nil {
+		t.Errorf("countryId 213 = %v, want both users", got)
+	}
+}
+
+func TestBuildClientDataTotalBalance(t *testing.T) {
+	c := newTestController(nil, date(2024, time.April, 15))
+	c.ref = loadReference(t, `reportingCurrency: USD
+currencies:
+  - {id: 1, code: TJS, rate: 0.1}
+  - {id: 4, code: USD}
+`)
+
+	client := map[string]interface{}{
+		"user": map[string]interface{}{"countryId": float64(213)},
+		"wallets": []interface{}{
+			map[string]interface{}{"no": "TJS-1", "isActive": float64(1), "balance": float64(100), "currencyId": float64(1)},
+			map[string]interface{}{"no": "USD-1", "isActive": float64(1), "balance": float64(2.5), "currencyId": float64(4)},
+			map[string]interface{}{"no": "UZS-1", "isActive": float64(0), "balance": float64(1000), "currencyId": float64(3)},
+		},
+	}
+	cd := c.BuildClientData(client, nil, nil, nil, 0, "1001", nil, 0, time.Time{})
+
+	if len(cd.Account.Wallets) != 3 {
+		t.Fatalf("Wallets = %+v, want all three", cd.Account.Wallets)
+	}
+	if active, ok := cd.Account.ActiveWallet(); !ok || active.Number != "TJS-1" || cd.Account.Wallets[1].Active {
+		t.Errorf("Wallets = %+v, want only the first active wallet flagged", cd.Account.Wallets)
+	}
+	if cd.Account.TotalBalance != 12.5 || cd.Account.TotalCurrency != "USD" {
+		t.Errorf("total balance = %v %q, want 12.5 USD", cd.Account.TotalBalance, cd.Account.TotalCurrency)
+	}
+	if len(cd.Account.Unconverted) != 1 || cd.Account.Unconverted[0] != 3 {
+		t.Errorf("Unconverted = %v, want [3]", cd.Account.Unconverted)
+	}
+}
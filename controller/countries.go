@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"action_users/models"
+	"action_users/reference"
+)
+
+// Reference is the country and currency table the controller reports with.
+func (c *Controller) Reference() *reference.Table {
+	return c.ref
+}
+
+// ResolveCountries turns the countryId and excludeCountryId parameters into
+// a selection. Both take comma separated country ids and names of the
+// configured country groups; countryId 0 or empty selects every country.
+func (c *Controller) ResolveCountries(include, exclude string) (models.Countries, error) {
+	var countries models.Countries
+	var err error
+	if countries.Include, err = c.countryIds(include); err != nil {
+		return countries, err
+	}
+	if countries.Exclude, err = c.countryIds(exclude); err != nil {
+		return countries, err
+	}
+	return countries, nil
+}
+
+func (c *Controller) countryIds(raw string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if id, err := strconv.Atoi(part); err == nil {
+			if id < 0 {
+				return nil, fmt.Errorf("invalid country id %d", id)
+			}
+			if id != 0 {
+				ids = append(ids, id)
+			}
+			continue
+		}
+		group, ok := c.ref.Group(part)
+		if !ok {
+			return nil, fmt.Errorf("unknown country group %q", part)
+		}
+		ids = append(ids, group...)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
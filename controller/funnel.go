@@ -0,0 +1,335 @@
+package controller
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"action_users/constants"
+	"action_users/models"
+)
+
+// Funnel steps, in the order a client is expected to reach them.
+const (
+	StepRegistered      = "registered"
+	StepFirstTopUp      = "firstTopUp"
+	StepFirstBet        = "firstBet"
+	StepFirstWithdrawal = "firstWithdrawal"
+)
+
+var funnelSteps = []string{StepRegistered, StepFirstTopUp, StepFirstBet, StepFirstWithdrawal}
+
+// funnelIndices are the action indices whose earliest document marks each
+// step after registration.
+var funnelIndices = [][]string{constants.TopUpIndices, constants.BetIndices, constants.WithdrawalIndices}
+
+const secondsPerDay = 24 * 60 * 60
+
+// FunnelOptions select the clients of a funnel: those matching Clients,
+// restricted to Countries and, as of AsOf, to clients registered by then.
+type FunnelOptions struct {
+	Countries models.Countries
+	AsOf      time.Time
+	Clients   models.ClientFilter
+}
+
+func (o FunnelOptions) clientFilter() models.ClientFilter {
+	filter := o.Clients
+	filter.Countries = o.Countries
+	if !o.AsOf.IsZero() {
+		filter.CreatedBefore = o.AsOf.Unix()
+	}
+	return filter
+}
+
+// DistributionBucket counts the durations in [From, To) days; To is 0 for
+// the open last bucket.
+type DistributionBucket struct {
+	From  int `json:"from"`
+	To    int `json:"to,omitempty"`
+	Count int `json:"count"`
+}
+
+// Distribution summarises durations in whole days.
+type Distribution struct {
+	Count   int                  `json:"count"`
+	P25     int                  `json:"p25"`
+	Median  int                  `json:"median"`
+	P75     int                  `json:"p75"`
+	P90     int                  `json:"p90"`
+	Buckets []DistributionBucket `json:"buckets"`
+}
+
+var distributionBounds = []int{0, 1, 7, 30, 90, 180, 365}
+
+// histogram counts durations by day so that percentiles stay exact without
+// keeping every sample.
+type histogram map[int]int
+
+func (h histogram) add(seconds int64) {
+	h[int(max(seconds, 0)/secondsPerDay)]++
+}
+
+func (h histogram) distribution() *Distribution {
+	if len(h) == 0 {
+		return nil
+	}
+	days := make([]int, 0, len(h))
+	d := &Distribution{}
+	for day, n := range h {
+		days = append(days, day)
+		d.Count += n
+	}
+	sort.Ints(days)
+
+	percentile := func(p float64) int {
+		rank := int(math.Ceil(p * float64(d.Count)))
+		seen := 0
+		for _, day := range days {
+			seen += h[day]
+			if seen >= rank {
+				return day
+			}
+		}
+		return days[len(days)-1]
+	}
+	d.P25, d.Median, d.P75, d.P90 = percentile(0.25), percentile(0.5), percentile(0.75), percentile(0.9)
+
+	for i, from := range distributionBounds {
+		b := DistributionBucket{From: from}
+		if i+1 < len(distributionBounds) {
+			b.To = distributionBounds[i+1]
+		}
+		for _, day := range days {
+			if day >= b.From && (b.To == 0 || day < b.To) {
+				b.Count += h[day]
+			}
+		}
+		d.Buckets = append(d.Buckets, b)
+	}
+	return d
+}
+
+// FunnelStep is one step of a funnel. TimeToStep is measured from
+// registration; Stalled clients reached this step but not the next one and
+// StalledFor is how long ago, as of asOf, they reached it.
+type FunnelStep struct {
+	Step       string        `json:"step"`
+	Users      int           `json:"users"`
+	Share      float64       `json:"share"`
+	Conversion float64       `json:"conversion"`
+	TimeToStep *Distribution `json:"timeToStep,omitempty"`
+	Stalled    int           `json:"stalled"`
+	StalledFor *Distribution `json:"stalledFor,omitempty"`
+}
+
+// FunnelGroup is the funnel of the clients of one country or platform.
+type FunnelGroup struct {
+	Key   string       `json:"key"`
+	Name  string       `json:"name,omitempty"`
+	Steps []FunnelStep `json:"steps"`
+}
+
+// Funnel is the registration-to-first-withdrawal funnel overall and by
+// country and platform.
+type Funnel struct {
+	AsOf       int64               `json:"asOf,omitempty"`
+	Countries  models.Countries    `json:"countries"`
+	Clients    models.ClientFilter `json:"clients"`
+	Steps      []FunnelStep        `json:"steps"`
+	ByCountry  []FunnelGroup       `json:"byCountry"`
+	ByPlatform []FunnelGroup       `json:"byPlatform"`
+}
+
+// funnelCounter accumulates the funnel of one group of clients.
+type funnelCounter struct {
+	users      []int
+	timeToStep []histogram
+	stalled    []int
+	stalledFor []histogram
+}
+
+func newFunnelCounter() *funnelCounter {
+	fc := &funnelCounter{
+		users:      make([]int, len(funnelSteps)),
+		timeToStep: make([]histogram, len(funnelSteps)),
+		stalled:    make([]int, len(funnelSteps)),
+		stalledFor: make([]histogram, len(funnelSteps)),
+	}
+	for i := range funnelSteps {
+		fc.timeToStep[i] = histogram{}
+		fc.stalledFor[i] = histogram{}
+	}
+	return fc
+}
+
+// add counts one client whose steps were reached at times; times[0] is the
+// registration and is 0 when unknown, later steps are 0 when not reached.
+func (fc *funnelCounter) add(times []int64, now int64) {
+	reached := 0
+	for i, t := range times {
+		if i > 0 && t == 0 {
+			break
+		}
+		fc.users[i]++
+		if i > 0 && times[0] != 0 {
+			fc.timeToStep[i].add(t - times[0])
+		}
+		reached = i
+	}
+	fc.stalled[reached]++
+	if since := times[reached]; since != 0 {
+		fc.stalledFor[reached].add(now - since)
+	}
+}
+
+func (fc *funnelCounter) steps() []FunnelStep {
+	steps := make([]FunnelStep, len(funnelSteps))
+	for i, name := range funnelSteps {
+		steps[i] = FunnelStep{
+			Step:       name,
+			Users:      fc.users[i],
+			Share:      ratio(fc.users[i], fc.users[0]),
+			Conversion: 1,
+			TimeToStep: fc.timeToStep[i].distribution(),
+			Stalled:    fc.stalled[i],
+			StalledFor: fc.stalledFor[i].distribution(),
+		}
+		if i > 0 {
+			steps[i].Conversion = ratio(fc.users[i], fc.users[i-1])
+		}
+	}
+	return steps
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*10000) / 10000
+}
+
+func funnelGroups(counters map[int]*funnelCounter, name func(id int) string) []FunnelGroup {
+	ids := make([]int, 0, len(counters))
+	for id := range counters {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	groups := make([]FunnelGroup, 0, len(ids))
+	for _, id := range ids {
+		g := FunnelGroup{Key: intKey(id), Steps: counters[id].steps()}
+		if name != nil && id != 0 {
+			g.Name = name(id)
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// FunnelAnalysis walks the clients matching opts through a point in time and
+// places each at the furthest step of registered, first top-up, first bet
+// and first withdrawal it reached in order, with the time it took from
+// registration. The first action of each step is read per page of clients
+// from the TopUpIndices, BetIndices and WithdrawalIndices groups.
+func (c *Controller) FunnelAnalysis(opts FunnelOptions) (Funnel, error) {
+	now := opts.AsOf
+	if now.IsZero() {
+		now = c.Now()
+	}
+	filter := opts.clientFilter()
+	var until int64
+	if !opts.AsOf.IsZero() {
+		until = opts.AsOf.Unix()
+	}
+
+	funnel := Funnel{
+		AsOf:      until,
+		Countries: opts.Countries,
+		Clients:   opts.Clients,
+	}
+
+	overall := newFunnelCounter()
+	byCountry := map[int]*funnelCounter{}
+	byPlatform := map[int]*funnelCounter{}
+	counter := func(m map[int]*funnelCounter, id int) *funnelCounter {
+		if m[id] == nil {
+			m[id] = newFunnelCounter()
+		}
+		return m[id]
+	}
+
+	cursor := models.Cursor{}
+	for {
+		userIds, next, err := c.GetUserIdsAfter(cursor, statsPageSize, filter)
+		if err != nil {
+			return funnel, err
+		}
+
+		if len(userIds) > 0 {
+			times, profiles, err := c.funnelTimes(userIds, until)
+			if err != nil {
+				if next != nil {
+					c.ReleaseCursor(*next)
+				}
+				return funnel, err
+			}
+			for _, uid := range userIds {
+				p, ok := profiles[uid]
+				if !ok {
+					continue
+				}
+				overall.add(times[uid], now.Unix())
+				counter(byCountry, p.countryId).add(times[uid], now.Unix())
+				counter(byPlatform, p.platform).add(times[uid], now.Unix())
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		cursor = *next
+	}
+
+	funnel.Steps = overall.steps()
+	funnel.ByCountry = funnelGroups(byCountry, c.ref.CountryName)
+	funnel.ByPlatform = funnelGroups(byPlatform, nil)
+	return funnel, nil
+}
+
+// funnelTimes reads the registration and first action of each funnel step
+// of a page of clients.
+func (c *Controller) funnelTimes(userIds []string, until int64) (map[string][]int64, map[string]clientProfile, error) {
+	clients, err := c.store.GetClientsByIds(userIds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	times := make(map[string][]int64, len(clients))
+	profiles := make(map[string]clientProfile, len(clients))
+	for uid, source := range clients {
+		p := profileOf(source)
+		profiles[uid] = p
+		times[uid] = make([]int64, len(funnelSteps))
+		times[uid][0] = p.createdAt
+	}
+
+	for step, indicesList := range funnelIndices {
+		for _, idx := range indicesList {
+			first, err := c.store.GetFirstActionTimes(userIds, idx, until)
+			if err != nil {
+				return nil, nil, err
+			}
+			for uid, t := range first {
+				ts, ok := times[uid]
+				if !ok {
+					continue
+				}
+				if ts[step+1] == 0 || t < ts[step+1] {
+					ts[step+1] = t
+				}
+			}
+		}
+	}
+	return times, profiles, nil
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/clock"
+	"action_users/constants"
+	"action_users/models"
+	"action_users/reference"
+	"action_users/repositories"
+)
+
+// Calendar arithmetic runs in the local zone of the unix timestamps, so pin
+// it to UTC to keep expectations independent of the machine and DST.
+func TestMain(m *testing.M) {
+	time.Local = time.UTC
+	os.Exit(m.Run())
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+}
+
+func ts(year int, month time.Month, day int) int64 {
+	return date(year, month, day).Unix()
+}
+
+// newTestController reads store with the clock stopped at now. A nil store
+// holds no documents.
+func newTestController(store repositories.Store, now time.Time) *Controller {
+	if store == nil {
+		store = repositories.NewMemoryStore(repositories.Fixtures{})
+	}
+	return NewController(store, 1, nil, reference.Default(), clock.NewFake(now))
+}
+
+// testFixtures builds the documents of a MemoryStore; the zero value holds
+// none.
+type testFixtures struct {
+	repositories.Fixtures
+}
+
+// client adds a client from countryId, on platform 1 and registered on
+// 2023-01-01.
+func (f *testFixtures) client(userId, countryId int) *testFixtures {
+	f.Clients = append(f.Clients, map[string]interface{}{
+		"stats": map[string]interface{}{"userId": float64(userId), "platform": float64(1)},
+		"user":  map[string]interface{}{"createdAt": float64(ts(2023, time.January, 1)), "countryId": float64(countryId)},
+	})
+	return f
+}
+
+// action adds an action of userId from countryId to index, worth amount in
+// currencyId.
+func (f *testFixtures) action(index string, userId, countryId int, at time.Time, amount float64, currencyId int) *testFixtures {
+	if f.Actions == nil {
+		f.Actions = map[string][]map[string]interface{}{}
+	}
+	f.Actions[index] = append(f.Actions[index], map[string]interface{}{
+		"user": map[string]interface{}{"id": float64(userId), "countryId": float64(countryId)},
+		constants.Indices[index]: map[string]interface{}{
+			"amount":     amount,
+			"currencyId": float64(currencyId),
+			"createdAt":  float64(at.Unix()),
+		},
+	})
+	return f
+}
+
+// bet adds a bet of 10 in currency 1.
+func (f *testFixtures) bet(userId, countryId int, at time.Time) *testFixtures {
+	return f.action("client_bets-searcher", userId, countryId, at, 10, 1)
+}
+
+func (f *testFixtures) store() *repositories.MemoryStore {
+	return repositories.NewMemoryStore(f.Fixtures)
+}
+
+// userIdsOf returns the sorted user ids of data.
+func userIdsOf(data []models.ClientData) []string {
+	ids := make([]string, 0, len(data))
+	for _, d := range data {
+		ids = append(ids, d.UserId)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// loadReference reads a reference table given in the YAML of
+// REFERENCE_FILE.
+func loadReference(t *testing.T, table string) *reference.Table {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "reference.yaml")
+	if err := os.WriteFile(path, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := reference.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+// loadFixtures serves the documents in repositories/testdata/fixtures.json.
+func loadFixtures(t *testing.T) *repositories.MemoryStore {
+	t.Helper()
+	store, err := repositories.LoadMemoryStore("../repositories/testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
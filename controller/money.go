@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"action_users/churn"
+	"action_users/constants"
+	"action_users/models"
+	"action_users/rules"
+)
+
+// DefaultMoneyWindow is the period of the windowed monetary totals when a
+// run does not set one.
+var DefaultMoneyWindow = rules.Window{Value: 3, Unit: rules.Months}
+
+// Positions of the ranges of pageRanges in the stats of an index.
+const (
+	rangeChurnPrior = iota
+	rangeChurnRecent
+	rangeLifetime
+	rangeWindow
+)
+
+// indexStats are the action stats of one user per index, one entry per
+// range of pageRanges.
+type indexStats map[string][]models.ActionStats
+
+// pageRanges are the periods read for every page of users: the two halves
+// of churn.Window, the whole history and the money window, all up to now.
+func pageRanges(now time.Time, window rules.Window) []models.ActionRange {
+	end := now.Unix()
+	mid := now.Add(-churn.Window / 2).Unix()
+	return []models.ActionRange{
+		rangeChurnPrior:  {From: now.Add(-churn.Window).Unix(), To: mid - 1},
+		rangeChurnRecent: {From: mid, To: end},
+		rangeLifetime:    {To: end},
+		rangeWindow:      {From: window.Before(now).Unix(), To: end},
+	}
+}
+
+// actionStats reads the stats of userIds over ranges with one aggregation
+// per action index. Users without actions in any range are absent.
+func (c *Controller) actionStats(userIds []string, ranges []models.ActionRange) (map[string]indexStats, error) {
+	out := map[string]indexStats{}
+	if len(userIds) == 0 {
+		return out, nil
+	}
+	for idx := range constants.Indices {
+		stats, err := c.store.GetActionStats(userIds, idx, ranges)
+		if err != nil {
+			return nil, err
+		}
+		for uid, st := range stats {
+			if out[uid] == nil {
+				out[uid] = indexStats{}
+			}
+			out[uid][idx] = st
+		}
+	}
+	return out, nil
+}
+
+// sum merges the stats of range r over indicesList.
+func (s indexStats) sum(indicesList []string, r int) models.ActionStats {
+	var total models.ActionStats
+	for _, idx := range indicesList {
+		if st, ok := s[idx]; ok && r < len(st) {
+			total.Add(st[r])
+		}
+	}
+	return total
+}
+
+// monetary converts the lifetime and window sums of deposits, bets and
+// withdrawals of one user to the reporting currency; nil when none is
+// configured.
+func (c *Controller) monetary(stats indexStats, windowFrom int64) *models.Monetary {
+	reporting, ok := c.ref.ReportingCurrency()
+	if !ok {
+		return nil
+	}
+	m := &models.Monetary{Currency: reporting.Code, WindowFrom: windowFrom}
+
+	unconverted := map[int]bool{}
+	// convert also returns how many of the actions were converted.
+	convert := func(st models.ActionStats) (float64, int) {
+		var total float64
+		var n int
+		for currencyId, amount := range st.Amounts {
+			v, ok := c.ref.Convert(amount, currencyId)
+			if !ok {
+				unconverted[currencyId] = true
+				continue
+			}
+			total += v
+			n += st.Counts[currencyId]
+		}
+		return round2(total), n
+	}
+	totals := func(r int) models.MoneyTotals {
+		bets := stats.sum(constants.BetIndices, r)
+		var t models.MoneyTotals
+		var converted int
+		t.Deposited, _ = convert(stats.sum(constants.TopUpIndices, r))
+		t.Wagered, converted = convert(bets)
+		t.Withdrawn, _ = convert(stats.sum(constants.WithdrawalIndices, r))
+		t.Bets = bets.Count
+		t.NetRevenue = round2(t.Deposited - t.Withdrawn)
+		if converted > 0 {
+			t.AverageBet = round2(t.Wagered / float64(converted))
+		}
+		return t
+	}
+	m.Lifetime = totals(rangeLifetime)
+	m.Window = totals(rangeWindow)
+
+	for currencyId := range unconverted {
+		m.Unconverted = append(m.Unconverted, currencyId)
+	}
+	sort.Ints(m.Unconverted)
+	return m
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// usersWithActions lists the users of uas that have any action.
+func usersWithActions(uas map[string]*models.UserActions) []string {
+	userIds := make([]string, 0, len(uas))
+	for uid, ua := range uas {
+		if ua != nil && len(ua.Recent) > 0 {
+			userIds = append(userIds, uid)
+		}
+	}
+	slices.Sort(userIds)
+	return userIds
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/constants"
+	"action_users/models"
+	"action_users/repositories"
+	"action_users/rules"
+)
+
+func TestMonetaryAverageBet(t *testing.T) {
+	c := newTestController(nil, date(2024, time.April, 15))
+	c.ref = loadReference(t, `reportingCurrency: USD
+currencies:
+  - {id: 1, code: TJS, rate: 0.1}
+  - {id: 3, code: UZS}
+  - {id: 4, code: USD}
+`)
+
+	bets := func(amounts map[int]float64, counts map[int]int) []models.ActionStats {
+		st := models.ActionStats{Amounts: amounts, Counts: counts}
+		for _, n := range counts {
+			st.Count += n
+		}
+		return []models.ActionStats{rangeLifetime: st, rangeWindow: {}}
+	}
+
+	tests := []struct {
+		name        string
+		stats       []models.ActionStats
+		wagered     float64
+		bets        int
+		averageBet  float64
+		unconverted []int
+	}{
+		{
+			name:       "converted currencies only",
+			stats:      bets(map[int]float64{1: 200, 4: 10}, map[int]int{1: 2, 4: 2}),
+			wagered:    30,
+			bets:       4,
+			averageBet: 7.5,
+		},
+		{
+			name:        "bets in a currency without a rate",
+			stats:       bets(map[int]float64{1: 200, 3: 30000}, map[int]int{1: 2, 3: 3}),
+			wagered:     20,
+			bets:        5,
+			averageBet:  10,
+			unconverted: []int{3},
+		},
+		{
+			name:        "no bet converted",
+			stats:       bets(map[int]float64{3: 30000}, map[int]int{3: 3}),
+			wagered:     0,
+			bets:        3,
+			averageBet:  0,
+			unconverted: []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := indexStats{constants.BetIndices[0]: tt.stats}
+			m := c.monetary(stats, 0)
+			if m == nil {
+				t.Fatal("monetary = nil with a reporting currency")
+			}
+			got := m.Lifetime
+			if got.Wagered != tt.wagered || got.Bets != tt.bets || got.AverageBet != tt.averageBet {
+				t.Errorf("lifetime = %+v, want wagered %v over %d bets, average %v", got, tt.wagered, tt.bets, tt.averageBet)
+			}
+			if !slices.Equal(m.Unconverted, tt.unconverted) {
+				t.Errorf("Unconverted = %v, want %v", m.Unconverted, tt.unconverted)
+			}
+		})
+	}
+}
+
+// statsCountingStore counts the stats aggregations over the action indices.
+type statsCountingStore struct {
+	*repositories.MemoryStore
+	aggregations int
+}
+
+func (s *statsCountingStore) GetActionStats(userIds []string, index string, ranges []models.ActionRange) (map[string][]models.ActionStats, error) {
+	s.aggregations++
+	return s.MemoryStore.GetActionStats(userIds, index, ranges)
+}
+
+func TestClassifyUsersReadsStatsOnlyWhenAsked(t *testing.T) {
+	store := &statsCountingStore{MemoryStore: loadFixtures(t)}
+	c := newTestController(store, date(2026, time.October, 17))
+	minRisk := 0.0
+
+	tests := []struct {
+		name   string
+		opts   ClassifyOptions
+		scored bool
+	}{
+		{name: "segments only", scored: false},
+		{name: "monetary", opts: ClassifyOptions{Monetary: true}, scored: true},
+		{name: "minRisk", opts: ClassifyOptions{MinRisk: &minRisk}, scored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.aggregations = 0
+			opts := tt.opts
+			opts.Months, opts.Rule = 3, rules.Default(3, rules.ModeGap)
+
+			segments, err := c.ClassifyUsers([]string{"1001", "1004"}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := store.aggregations > 0; got != tt.scored {
+				t.Errorf("%d stats aggregations, want them only when scored", store.aggregations)
+			}
+			if len(segments.Inactive) == 0 {
+				t.Fatal("no inactive users")
+			}
+			for _, cd := range segments.Inactive {
+				if got := cd.RiskBand != ""; got != tt.scored {
+					t.Errorf("%s: riskBand %q, scored = %v", cd.UserId, cd.RiskBand, tt.scored)
+				}
+			}
+		})
+	}
+}
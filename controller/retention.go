@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"action_users/models"
+	"action_users/rules"
+)
+
+// RetentionCohort is one row of the retention matrix: the clients registered
+// in Cohort and, for each month k since registration, how many of them had
+// any action in that month. A row runs up to the month of asOf, which is
+// still in progress and counts actions up to asOf only; later months are
+// left out rather than reported as zero.
+type RetentionCohort struct {
+	Cohort    string    `json:"cohort"`
+	Size      int       `json:"size"`
+	Active    []int     `json:"active"`
+	Retention []float64 `json:"retention"`
+}
+
+// Retention is the cohort retention matrix of the clients registered in the
+// last Months months before AsOf. PartialMonth is the month of AsOf, the
+// last column of every cohort.
+type Retention struct {
+	Months       int               `json:"months"`
+	AsOf         int64             `json:"asOf,omitempty"`
+	PartialMonth string            `json:"partialMonth"`
+	Countries    models.Countries  `json:"countries"`
+	Cohorts      []RetentionCohort `json:"cohorts"`
+}
+
+// monthIndex numbers calendar months so that consecutive months differ by one.
+func monthIndex(t time.Time) int {
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// RetentionMatrix builds the retention matrix of the clients matching
+// opts.Countries registered from the start of the month opts.Months months
+// before asOf. It walks those clients through a point in time and asks the
+// store in which months each page of them had any action.
+func (c *Controller) RetentionMatrix(opts StatsOptions) (Retention, error) {
+	now := opts.AsOf
+	if now.IsZero() {
+		now = c.Now()
+	}
+	now = now.UTC()
+	first := monthStart(rules.MonthsBefore(now, opts.Months))
+	until := opts.until()
+
+	retention := Retention{
+		Months:       opts.Months,
+		AsOf:         until,
+		PartialMonth: now.Format("2006-01"),
+		Countries:    opts.Countries,
+	}
+
+	cohorts := make([]RetentionCohort, 0, opts.Months+1)
+	for m := first; !m.After(now); m = m.AddDate(0, 1, 0) {
+		// Month k of this cohort is only reported once it has started; the
+		// last one is the month of now.
+		elapsed := monthIndex(now) - monthIndex(m) + 1
+		cohorts = append(cohorts, RetentionCohort{
+			Cohort:    m.Format("2006-01"),
+			Active:    make([]int, elapsed),
+			Retention: make([]float64, elapsed),
+		})
+	}
+
+	filter := models.ClientFilter{Countries: opts.Countries, CreatedFrom: first.Unix(), CreatedBefore: until}
+	actions := models.ActionRange{From: first.Unix(), To: until}
+
+	cursor := models.Cursor{}
+	for {
+		userIds, next, err := c.GetUserIdsAfter(cursor, statsPageSize, filter)
+		if err != nil {
+			return retention, err
+		}
+
+		if len(userIds) > 0 {
+			if err := c.countRetention(userIds, actions, first, cohorts); err != nil {
+				if next != nil {
+					c.ReleaseCursor(*next)
+				}
+				return retention, err
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		cursor = *next
+	}
+
+	for i := range cohorts {
+		if cohorts[i].Size == 0 {
+			continue
+		}
+		for k, n := range cohorts[i].Active {
+			share := float64(n) / float64(cohorts[i].Size)
+			cohorts[i].Retention[k] = math.Round(share*10000) / 10000
+		}
+	}
+	retention.Cohorts = cohorts
+	return retention, nil
+}
+
+// countRetention adds one page of clients to the cohorts, which start at the
+// month first.
+func (c *Controller) countRetention(userIds []string, actions models.ActionRange, first time.Time, cohorts []RetentionCohort) error {
+	clients, err := c.store.GetClientsByIds(userIds)
+	if err != nil {
+		return err
+	}
+	months, err := c.store.GetActionMonths(userIds, actions)
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range userIds {
+		createdAt, ok := registeredAt(clients[uid])
+		if !ok {
+			continue
+		}
+		registered := monthIndex(time.Unix(createdAt, 0).UTC())
+		i := registered - monthIndex(first)
+		if i < 0 || i >= len(cohorts) {
+			continue
+		}
+		cohort := &cohorts[i]
+		cohort.Size++
+
+		for _, month := range months[uid] {
+			t, err := time.Parse("2006-01", month)
+			if err != nil {
+				continue
+			}
+			k := monthIndex(t) - registered
+			if k >= 0 && k < len(cohort.Active) {
+				cohort.Active[k]++
+			}
+		}
+	}
+	return nil
+}
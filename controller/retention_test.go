@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionMatrixPartialMonth(t *testing.T) {
+	c := newTestController(loadFixtures(t), date(2026, time.October, 17))
+
+	retention, err := c.RetentionMatrix(StatsOptions{Months: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retention.PartialMonth != "2026-10" {
+		t.Errorf("PartialMonth = %q, want 2026-10", retention.PartialMonth)
+	}
+
+	want := []struct {
+		cohort string
+		size   int
+		months int
+	}{
+		// 1003 registered in August and has no actions.
+		{cohort: "2026-08", size: 1, months: 3},
+		{cohort: "2026-09", size: 0, months: 2},
+		{cohort: "2026-10", size: 0, months: 1},
+	}
+	if len(retention.Cohorts) != len(want) {
+		t.Fatalf("got %d cohorts, want %d", len(retention.Cohorts), len(want))
+	}
+	for i, w := range want {
+		got := retention.Cohorts[i]
+		if got.Cohort != w.cohort || got.Size != w.size || len(got.Active) != w.months || len(got.Retention) != w.months {
+			t.Errorf("cohort %d = %+v, want %s of %d over %d months up to the partial one", i, got, w.cohort, w.size, w.months)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"action_users/constants"
+	"action_users/models"
+	"action_users/rfm"
+	"action_users/rules"
+)
+
+// RFMOptions select the clients of an RFM segmentation the way
+// ClassifyOptions do for ProcessUsers; Window is how far back from AsOf
+// frequency and monetary value are counted.
+type RFMOptions struct {
+	Countries models.Countries
+	Window    rules.Window
+	AsOf      time.Time
+	Clients   models.ClientFilter
+}
+
+// RFMUser is the RFM profile of one user: days since the last action, the
+// number of actions and the top-up amount in the window, with their
+// quintiles and segment. Monetary is in the reporting currency, leaving out
+// top-ups in Unconverted currencies, or in the user's own currency when no
+// reporting currency is configured.
+type RFMUser struct {
+	UserId      string  `json:"userId"`
+	CountryId   int     `json:"countryId"`
+	RecencyDays float64 `json:"recencyDays"`
+	Frequency   int     `json:"frequency"`
+	Monetary    float64 `json:"monetary"`
+	Unconverted []int   `json:"unconverted,omitempty"`
+	rfm.Scores
+	Segment string `json:"segment"`
+}
+
+// RFMSegmentCount is the size of one segment.
+type RFMSegmentCount struct {
+	Segment string  `json:"segment"`
+	Users   int     `json:"users"`
+	Share   float64 `json:"share"`
+}
+
+// RFMResult is an RFM segmentation of every client matching the options,
+// computed at ComputedAt. Clients without any action are left out and only
+// counted in NoActions. Currency is the reporting currency of the monetary
+// values, empty when there is none and they stay in local currencies.
+type RFMResult struct {
+	Window       string              `json:"window"`
+	From         int64               `json:"from"`
+	AsOf         int64               `json:"asOf,omitempty"`
+	ComputedAt   int64               `json:"computedAt"`
+	Currency     string              `json:"currency,omitempty"`
+	Countries    models.Countries    `json:"countries"`
+	Clients      models.ClientFilter `json:"clients"`
+	UsersScanned int                 `json:"usersScanned"`
+	NoActions    int                 `json:"noActions"`
+	Segments     []RFMSegmentCount   `json:"segments"`
+	Users        []RFMUser           `json:"-"`
+}
+
+// rfmCacheTTL is how long an RFM segmentation is served from memory before
+// its population is scanned again.
+const rfmCacheTTL = 15 * time.Minute
+
+// rfmCacheSize bounds how many segmentations are kept; the oldest goes
+// first.
+const rfmCacheSize = 8
+
+// rfmCache holds the latest RFM segmentations by their options, so that
+// paging through one does not rescan the population for every page.
+type rfmCache struct {
+	mu      sync.Mutex
+	entries map[string]*rfmEntry
+}
+
+// rfmEntry is one segmentation, in progress until done is closed.
+type rfmEntry struct {
+	done    chan struct{}
+	started time.Time
+	result  RFMResult
+	err     error
+}
+
+func rfmKey(opts RFMOptions) string {
+	key, _ := json.Marshal(struct {
+		Countries models.Countries    `json:"countries"`
+		Window    string              `json:"window"`
+		AsOf      int64               `json:"asOf"`
+		Clients   models.ClientFilter `json:"clients"`
+	}{opts.Countries, opts.Window.String(), ClassifyOptions{AsOf: opts.AsOf}.Until(), opts.Clients})
+	return string(key)
+}
+
+// RFMSegments returns the RFM segmentation of the clients matching opts.
+// A segmentation is computed once and served from memory for rfmCacheTTL;
+// concurrent requests for the same options wait for the scan in progress.
+// The result is shared and must not be modified.
+func (c *Controller) RFMSegments(opts RFMOptions) (RFMResult, error) {
+	key := rfmKey(opts)
+	now := c.Now()
+
+	c.rfm.mu.Lock()
+	if e, ok := c.rfm.entries[key]; ok && now.Sub(e.started) < rfmCacheTTL {
+		c.rfm.mu.Unlock()
+		<-e.done
+		return e.result, e.err
+	}
+	e := &rfmEntry{done: make(chan struct{}), started: now}
+	if c.rfm.entries == nil {
+		c.rfm.entries = map[string]*rfmEntry{}
+	}
+	c.rfm.entries[key] = e
+	if len(c.rfm.entries) > rfmCacheSize {
+		oldest := key
+		for k, other := range c.rfm.entries {
+			if other.started.Before(c.rfm.entries[oldest].started) {
+				oldest = k
+			}
+		}
+		delete(c.rfm.entries, oldest)
+	}
+	c.rfm.mu.Unlock()
+
+	e.result, e.err = c.computeRFM(opts, now)
+	close(e.done)
+	if e.err != nil {
+		c.rfm.mu.Lock()
+		if c.rfm.entries[key] == e {
+			delete(c.rfm.entries, key)
+		}
+		c.rfm.mu.Unlock()
+	}
+	return e.result, e.err
+}
+
+// computeRFM walks the clients matching opts with the same scan as
+// ProcessUsers, reads their latest action with the same country fallback
+// and their actions in the window with one aggregation per action index,
+// then scores recency, frequency and monetary value in quintiles over the
+// whole population. Without a reporting currency, monetary quintiles are
+// ranked within each country so that amounts in different currencies are
+// never compared.
+func (c *Controller) computeRFM(opts RFMOptions, computedAt time.Time) (RFMResult, error) {
+	now := opts.AsOf
+	if now.IsZero() {
+		now = computedAt
+	}
+	classify := ClassifyOptions{Countries: opts.Countries, AsOf: opts.AsOf, Clients: opts.Clients}
+	from := opts.Window.Before(now).Unix()
+
+	result := RFMResult{
+		Window:     opts.Window.String(),
+		From:       from,
+		AsOf:       classify.Until(),
+		ComputedAt: computedAt.Unix(),
+		Countries:  opts.Countries,
+		Clients:    opts.Clients,
+	}
+	reporting, converted := c.ref.ReportingCurrency()
+	if converted {
+		result.Currency = reporting.Code
+	}
+
+	cursor := models.Cursor{}
+	for {
+		userIds, next, err := c.GetUserIdsAfter(cursor, statsPageSize, classify.ClientFilter())
+		if err != nil {
+			return result, err
+		}
+
+		if len(userIds) > 0 {
+			users, err := c.rfmProfiles(userIds, classify, models.ActionRange{From: from, To: now.Unix()}, now)
+			if err != nil {
+				if next != nil {
+					c.ReleaseCursor(*next)
+				}
+				return result, err
+			}
+			result.UsersScanned += len(userIds)
+			result.NoActions += len(userIds) - len(users)
+			result.Users = append(result.Users, users...)
+		}
+
+		if next == nil {
+			break
+		}
+		cursor = *next
+	}
+
+	scoreRFM(result.Users, !converted)
+
+	counts := map[string]int{}
+	for _, u := range result.Users {
+		counts[u.Segment]++
+	}
+	for _, name := range rfm.Segments {
+		result.Segments = append(result.Segments, RFMSegmentCount{
+			Segment: name,
+			Users:   counts[name],
+			Share:   ratio(counts[name], len(result.Users)),
+		})
+	}
+	return result, nil
+}
+
+// rfmProfiles reads the raw recency, frequency and monetary value of a page
+// of users; users without actions are left out.
+func (c *Controller) rfmProfiles(userIds []string, opts ClassifyOptions, window models.ActionRange, now time.Time) ([]RFMUser, error) {
+	userActions, err := c.GetLastActionsForUsers(userIds, opts.Countries, opts.Until())
+	if err != nil {
+		return nil, err
+	}
+	clients, err := c.store.GetClientsByIds(userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := c.actionStats(userIds, []models.ActionRange{window})
+	if err != nil {
+		return nil, err
+	}
+	_, converted := c.ref.ReportingCurrency()
+
+	users := make([]RFMUser, 0, len(userIds))
+	for _, uid := range userIds {
+		ua := userActions[uid]
+		if ua == nil || len(ua.Recent) == 0 {
+			continue
+		}
+		last := ua.Recent[0]
+		countryId := profileOf(clients[uid]).countryId
+		if countryId == 0 {
+			countryId = last.CountryId
+		}
+		u := RFMUser{
+			UserId:      uid,
+			CountryId:   countryId,
+			RecencyDays: math.Round(float64(max(now.Unix()-last.CreatedAt, 0))/secondsPerDay*10) / 10,
+		}
+		for _, st := range stats[uid] {
+			u.Frequency += st[0].Count
+		}
+		topUps := stats[uid].sum(constants.TopUpIndices, 0)
+		if converted {
+			for currencyId, amount := range topUps.Amounts {
+				v, ok := c.ref.Convert(amount, currencyId)
+				if !ok {
+					u.Unconverted = append(u.Unconverted, currencyId)
+					continue
+				}
+				u.Monetary += v
+			}
+			u.Monetary = round2(u.Monetary)
+			slices.Sort(u.Unconverted)
+		} else {
+			u.Monetary = topUps.Amount()
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// scoreRFM sets the quintiles and segment of every user: recency and
+// frequency across all of them, monetary value too unless byCountry asks
+// for it within each country.
+func scoreRFM(users []RFMUser, byCountry bool) {
+	recency := make([]float64, len(users))
+	frequency := make([]float64, len(users))
+	groups := map[int][]int{}
+	for i, u := range users {
+		// Fewer days since the last action is better.
+		recency[i] = -u.RecencyDays
+		frequency[i] = float64(u.Frequency)
+		country := 0
+		if byCountry {
+			country = u.CountryId
+		}
+		groups[country] = append(groups[country], i)
+	}
+
+	r, f := rfm.Quintiles(recency), rfm.Quintiles(frequency)
+	for i := range users {
+		users[i].R, users[i].F = r[i], f[i]
+	}
+	for _, members := range groups {
+		monetary := make([]float64, len(members))
+		for j, i := range members {
+			monetary[j] = users[i].Monetary
+		}
+		for j, m := range rfm.Quintiles(monetary) {
+			users[members[j]].M = m
+		}
+	}
+	for i := range users {
+		users[i].Segment = rfm.Segment(users[i].Scores)
+	}
+}
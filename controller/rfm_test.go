@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"action_users/clock"
+	"action_users/models"
+	"action_users/repositories"
+	"action_users/rfm"
+	"action_users/rules"
+)
+
+func TestScoreRFM(t *testing.T) {
+	users := []RFMUser{
+		// Tajikistan spends in somoni, Uzbekistan in sum: without a
+		// reporting currency only the ranking within each country decides M.
+		{UserId: "tj-high", CountryId: 213, RecencyDays: 1, Frequency: 50, Monetary: 900},
+		{UserId: "tj-mid", CountryId: 213, RecencyDays: 10, Frequency: 20, Monetary: 300},
+		{UserId: "tj-low", CountryId: 213, RecencyDays: 100, Frequency: 1, Monetary: 10},
+		{UserId: "uz-high", CountryId: 233, RecencyDays: 2, Frequency: 40, Monetary: 500000},
+		{UserId: "uz-low", CountryId: 233, RecencyDays: 60, Frequency: 2, Monetary: 100000},
+	}
+	scoreRFM(users, true)
+
+	want := map[string]rfm.Scores{
+		"tj-high": {R: 5, F: 5, M: 4},
+		"tj-mid":  {R: 3, F: 3, M: 2},
+		"tj-low":  {R: 1, F: 1, M: 1},
+		"uz-high": {R: 4, F: 4, M: 3},
+		"uz-low":  {R: 2, F: 2, M: 1},
+	}
+	for _, u := range users {
+		if u.Scores != want[u.UserId] {
+			t.Errorf("%s scores = %+v, want %+v", u.UserId, u.Scores, want[u.UserId])
+		}
+		if u.Segment != rfm.Segment(u.Scores) {
+			t.Errorf("%s segment = %s, want %s", u.UserId, u.Segment, rfm.Segment(u.Scores))
+		}
+	}
+}
+
+func TestScoreRFMTies(t *testing.T) {
+	users := []RFMUser{
+		{UserId: "a", CountryId: 213, RecencyDays: 5, Frequency: 3},
+		{UserId: "b", CountryId: 213, RecencyDays: 5, Frequency: 3},
+		{UserId: "c", CountryId: 213, RecencyDays: 5, Frequency: 3},
+	}
+	scoreRFM(users, false)
+
+	for _, u := range users {
+		if u.Scores != (rfm.Scores{R: 1, F: 1, M: 1}) || u.Segment != rfm.Lost {
+			t.Errorf("%s = %+v %s, want identical users scored alike", u.UserId, u.Scores, u.Segment)
+		}
+	}
+}
+
+func TestRFMSegmentsConvertsMonetary(t *testing.T) {
+	var f testFixtures
+	f.client(1, 213).client(2, 233).client(3, 213)
+	topUps := "client_online_top_ups-searcher"
+	f.action(topUps, 1, 213, date(2024, time.March, 1), 1000, 1)  // 100 USD
+	f.action(topUps, 2, 233, date(2024, time.March, 1), 50000, 3) // 5 USD
+	f.action(topUps, 3, 213, date(2024, time.March, 1), 20, 4)    // 20 USD
+	f.action(topUps, 3, 213, date(2024, time.March, 2), 99, 5)    // no rate
+	c := newTestController(f.store(), date(2024, time.April, 15))
+	c.ref = loadReference(t, `reportingCurrency: USD
+currencies:
+  - {id: 1, code: TJS, rate: 0.1}
+  - {id: 3, code: UZS, rate: 0.0001}
+  - {id: 4, code: USD}
+  - {id: 5, code: RUB}
+`)
+
+	result, err := c.RFMSegments(RFMOptions{Window: rules.Window{Value: 12, Unit: rules.Months}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Currency != "USD" {
+		t.Errorf("Currency = %q, want USD", result.Currency)
+	}
+	want := map[string]struct {
+		monetary    float64
+		unconverted int
+	}{
+		"1": {100, 0},
+		"2": {5, 0},
+		"3": {20, 5},
+	}
+	for _, u := range result.Users {
+		w := want[u.UserId]
+		if u.Monetary != w.monetary {
+			t.Errorf("%s: Monetary = %v, want %v", u.UserId, u.Monetary, w.monetary)
+		}
+		if w.unconverted != 0 && (len(u.Unconverted) != 1 || u.Unconverted[0] != w.unconverted) {
+			t.Errorf("%s: Unconverted = %v, want [%d]", u.UserId, u.Unconverted, w.unconverted)
+		}
+	}
+	// Converted amounts are ranked across countries.
+	if len(result.Users) != 3 || result.Users[0].M <= result.Users[2].M || result.Users[2].M <= result.Users[1].M {
+		t.Errorf("users = %+v, want M ranked 1 > 3 > 2", result.Users)
+	}
+}
+
+// scanCountingStore counts the pages of clients read by full scans.
+type scanCountingStore struct {
+	*repositories.MemoryStore
+	scans int
+}
+
+func (s *scanCountingStore) GetUserIdsAfter(cursor models.Cursor, size int, filter models.ClientFilter) ([]string, *models.Cursor, error) {
+	s.scans++
+	return s.MemoryStore.GetUserIdsAfter(cursor, size, filter)
+}
+
+func TestRFMSegmentsCache(t *testing.T) {
+	var f testFixtures
+	f.client(1, 213).bet(1, 213, date(2024, time.March, 1))
+	store := &scanCountingStore{MemoryStore: f.store()}
+	c := newTestController(store, date(2024, time.April, 15))
+	clk := c.clock.(*clock.Fake)
+	opts := RFMOptions{Window: rules.Window{Value: 12, Unit: rules.Months}}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		window  rules.Window
+		scans   int
+	}{
+		{"first request", 0, opts.Window, 1},
+		{"within the TTL", rfmCacheTTL / 2, opts.Window, 1},
+		{"other window", 0, rules.Window{Value: 6, Unit: rules.Months}, 2},
+		{"after the TTL", rfmCacheTTL, opts.Window, 3},
+	}
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		result, err := c.RFMSegments(RFMOptions{Window: tt.window})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if store.scans != tt.scans {
+			t.Errorf("%s: %d scans, want %d", tt.name, store.scans, tt.scans)
+		}
+		if len(result.Users) != 1 {
+			t.Errorf("%s: %d users, want 1", tt.name, len(result.Users))
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"action_users/churn"
+	"action_users/models"
+	"action_users/pool"
+	"action_users/repositories"
+	"action_users/rules"
+)
+
+// ClassifyOptions are the parameters of one segmentation run. Months sets
+// the reactivation threshold and, when Rule is the default one, the
+// inactivity window. A non-zero AsOf evaluates the whole run as of that
+// moment: clients registered and actions created after it are ignored.
+// Countries applies to clients-searcher and the action indices alike.
+// Clients narrows the scan of clients-searcher further; its Countries and
+// CreatedBefore are taken from the options themselves. A non-nil MinRisk
+// adds the active users whose churn score reaches it as AtRisk; it does
+// not filter the other segments. Monetary adds the churn score and the
+// monetary totals to the users with actions; Window is the period of the
+// windowed totals, DefaultMoneyWindow when zero.
+type ClassifyOptions struct {
+	Countries models.Countries
+	Months    int
+	Rule      rules.Rule
+	AsOf      time.Time
+	Clients   models.ClientFilter
+	MinRisk   *float64
+	Monetary  bool
+	Window    rules.Window
+}
+
+// needsStats reports whether a run reads the action stats of its pages.
+func (o ClassifyOptions) needsStats() bool {
+	return o.MinRisk != nil || o.Monetary
+}
+
+func (o ClassifyOptions) moneyWindow() rules.Window {
+	if o.Window.Value == 0 {
+		return DefaultMoneyWindow
+	}
+	return o.Window
+}
+
+// Until is AsOf in unix seconds, 0 when the run is evaluated now.
+func (o ClassifyOptions) Until() int64 {
+	if o.AsOf.IsZero() {
+		return 0
+	}
+	return o.AsOf.Unix()
+}
+
+func (o ClassifyOptions) ClientFilter() models.ClientFilter {
+	filter := o.Clients
+	filter.Countries = o.Countries
+	filter.CreatedBefore = o.Until()
+	return filter
+}
+
+// ClassifyUsers runs the inactivity classification for one page of users:
+// users with no actions land in RegisteredNoActions, inactive users with a
+// client document in Inactive and inactive users without one in Orphan.
+// Active users are left out unless opts.MinRisk is set, when those with a
+// client document and a score reaching it land in AtRisk. When MinRisk or
+// Monetary is set, each page also costs one stats aggregation per action
+// index, which gives the churn features and the monetary totals of its
+// users.
+func (c *Controller) ClassifyUsers(userIds []string, opts ClassifyOptions) (models.Segments, error) {
+	var segments models.Segments
+	months := opts.Months
+	now := opts.AsOf
+	if now.IsZero() {
+		now = c.Now()
+	}
+
+	userActions, err := c.GetLastActionsForUsers(userIds, opts.Countries, opts.Until())
+	if err != nil {
+		return segments, err
+	}
+
+	ranges := pageRanges(now, opts.moneyWindow())
+	var stats map[string]indexStats
+	var features map[string]churn.Features
+	if opts.needsStats() {
+		stats, err = c.actionStats(usersWithActions(userActions), ranges)
+		if err != nil {
+			return segments, err
+		}
+		features = churnFeatures(userActions, stats, now)
+	}
+
+	var mu sync.Mutex
+	pool.Run(c.workers, userIds, func(uid string) {
+		f, scored := features[uid]
+		atRisk := opts.MinRisk != nil && scored && churn.Score(f) >= *opts.MinRisk
+
+		cl, err := c.classifyUser(uid, userActions[uid], opts, now, atRisk)
+		if err != nil {
+			log.Printf("warn: getClientById(%s) error: %v", uid, err)
+			return
+		}
+		if scored {
+			scoreChurn(&cl.Data, f)
+			cl.Data.Monetary = c.monetary(stats[uid], ranges[rangeWindow].From)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch cl.Data.Segment {
+		case models.SegmentActive:
+			if cl.ClientFound {
+				cl.Data.Segment = models.SegmentAtRisk
+				segments.AtRisk = append(segments.AtRisk, cl.Data)
+			}
+		case models.SegmentRegisteredNoActions:
+			segments.RegisteredNoActions = append(segments.RegisteredNoActions, cl.Data)
+		case models.SegmentOrphan:
+			segments.Orphan = append(segments.Orphan, cl.Data)
+		case models.SegmentInactive:
+			segments.Inactive = append(segments.Inactive, cl.Data)
+		}
+	})
+
+	log.Printf("info: processed %d users: %d inactive, %d orphan, %d registered no actions, %d at risk (months=%d, rule=%s)",
+		len(userIds), len(segments.Inactive), len(segments.Orphan), len(segments.RegisteredNoActions), len(segments.AtRisk), months, opts.Rule.Name)
+
+	return segments, nil
+}
+
+// classification is the outcome of classifyUser. Data.Segment is empty
+// when the user has neither actions nor a client document.
+type classification struct {
+	Data        models.ClientData
+	Inactive    bool
+	Reasons     []string
+	ClientFound bool
+}
+
+// classifyUser is one iteration of ClassifyUsers: ua are the latest actions
+// of the user, nil when there are none. Active users are only looked up and
+// built when withActive is set; otherwise their Data.Segment stays empty.
+func (c *Controller) classifyUser(uid string, ua *models.UserActions, opts ClassifyOptions, now time.Time, withActive bool) (classification, error) {
+	var cl classification
+	months := opts.Months
+	// Orphans and the currency fallback need a single country.
+	countryId := opts.Countries.Single()
+
+	var actions []models.Action
+	if ua != nil {
+		actions = ua.Recent
+	}
+
+	if len(actions) == 0 {
+		clientHit, err := c.GetClientById(uid, opts.Countries)
+		if err != nil {
+			return cl, err
+		}
+		if clientHit == nil {
+			log.Printf("warn: registered user not found AND no actions for userId: %s", uid)
+			return cl, nil
+		}
+		cl.ClientFound = true
+		cl.Data = c.BuildClientData(clientHit, nil, nil, nil, countryId, uid, actions, 0, opts.AsOf)
+		cl.Data.Segment = models.SegmentRegisteredNoActions
+		return cl, nil
+	}
+
+	cl.Inactive, cl.Reasons = opts.Rule.Evaluate(ua, now)
+	log.Printf("debug: user %s - actions: %d, isInactive: %t (rule=%s, reasons=%v)", uid, len(actions), cl.Inactive, opts.Rule.Name, cl.Reasons)
+
+	if !cl.Inactive && !withActive {
+		return cl, nil
+	}
+
+	clientHit, err := c.GetClientById(uid, opts.Countries)
+	if err != nil {
+		return cl, err
+	}
+	cl.ClientFound = clientHit != nil
+
+	if !cl.Inactive {
+		cl.Data = c.BuildClientData(clientHit, ua.TopUp, ua.Bet, ua.Withdrawal, countryId, uid, actions, months, opts.AsOf)
+		cl.Data.Segment = models.SegmentActive
+		return cl, nil
+	}
+
+	if clientHit == nil {
+		log.Printf("info: orphan user %s - no client data but has actions", uid)
+		cl.Data = c.BuildClientData(nil, ua.TopUp, ua.Bet, ua.Withdrawal, countryId, uid, actions, months, opts.AsOf)
+		cl.Data.Segment = models.SegmentOrphan
+		cl.Data.InactivityRule = opts.Rule.Name
+		cl.Data.InactivityReasons = cl.Reasons
+		return cl, nil
+	}
+
+	cl.Data = c.BuildClientData(clientHit, ua.TopUp, ua.Bet, ua.Withdrawal, countryId, uid, actions, months, opts.AsOf)
+	cl.Data.Segment = models.SegmentInactive
+	cl.Data.InactivityRule = opts.Rule.Name
+	cl.Data.InactivityReasons = cl.Reasons
+
+	if cl.Data.ReactivationThreshold > 0 {
+		thresholdDate := time.Unix(cl.Data.ReactivationThreshold, 0)
+		lastActivityDate := time.Unix(cl.Data.LastActivity, 0)
+		log.Printf("info: user %s became inactive, last activity: %s, reactivation threshold: %s (months=%d)",
+			uid, lastActivityDate.Format("2006-01-02"), thresholdDate.Format("2006-01-02"), months)
+	}
+	return cl, nil
+}
+
+// ClassifyBatch runs ClassifyUsers over an arbitrary list of users,
+// pageSize users at a time, and merges the segments of all pages.
+func (c *Controller) ClassifyBatch(userIds []string, opts ClassifyOptions, pageSize int) (models.Segments, error) {
+	var all models.Segments
+	for start := 0; start < len(userIds); start += pageSize {
+		end := min(start+pageSize, len(userIds))
+		segments, err := c.ClassifyUsers(userIds[start:end], opts)
+		if err != nil {
+			return all, err
+		}
+		all.Inactive = append(all.Inactive, segments.Inactive...)
+		all.Orphan = append(all.Orphan, segments.Orphan...)
+		all.RegisteredNoActions = append(all.RegisteredNoActions, segments.RegisteredNoActions...)
+		all.AtRisk = append(all.AtRisk, segments.AtRisk...)
+	}
+	return all, nil
+}
+
+// WalkSegments classifies every client matching opts.ClientFilter, pageSize
+// users at a time, walking clients-searcher through a point in time. fn
+// receives the segments of each page in order; an error from fn stops the
+// walk.
+func (c *Controller) WalkSegments(opts ClassifyOptions, pageSize int, fn func(userIds []string, segments models.Segments) error) error {
+	cursor := models.Cursor{}
+	for {
+		userIds, next, err := c.GetUserIdsAfter(cursor, pageSize, opts.ClientFilter())
+		if err != nil {
+			return err
+		}
+
+		if len(userIds) > 0 {
+			segments, err := c.ClassifyUsers(userIds, opts)
+			if err != nil {
+				if next != nil {
+					c.ReleaseCursor(*next)
+				}
+				return err
+			}
+			if err := fn(userIds, segments); err != nil {
+				if next != nil {
+					c.ReleaseCursor(*next)
+				}
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		cursor = *next
+	}
+}
+
+// ReleaseCursor frees the point in time behind a cursor that will not be
+// walked further, logging rather than returning a failure.
+func (c *Controller) ReleaseCursor(cursor models.Cursor) {
+	if err := c.store.ReleaseCursor(cursor); err != nil {
+		log.Printf("warn: failed to release cursor: %v", err)
+	}
+}
+
+// SegmentsIndex names the index a segmentation run is written to: dated by
+// opts.AsOf for historical runs and by the current day otherwise.
+func (c *Controller) SegmentsIndex(opts ClassifyOptions) string {
+	if !opts.AsOf.IsZero() {
+		return repositories.SegmentsIndexName(opts.AsOf)
+	}
+	return repositories.SegmentsIndexName(c.Now())
+}
+
+// SaveSegments writes the classified users of segments to index, keyed by
+// userId.
+func (c *Controller) SaveSegments(index string, segments models.Segments, opts ClassifyOptions) error {
+	computedAt := c.Now().Unix()
+	docs := make([]models.SegmentDocument, 0, len(segments.Inactive)+len(segments.Orphan)+len(segments.RegisteredNoActions)+len(segments.AtRisk))
+	for _, bucket := range [][]models.ClientData{segments.Inactive, segments.Orphan, segments.RegisteredNoActions, segments.AtRisk} {
+		for _, cd := range bucket {
+			docs = append(docs, models.SegmentDocument{
+				ClientData: cd,
+				Months:     opts.Months,
+				AsOf:       opts.Until(),
+				ComputedAt: computedAt,
+			})
+		}
+	}
+	if err := c.store.SaveSegments(index, docs); err != nil {
+		return err
+	}
+	log.Printf("info: saved %d segment documents to %s", len(docs), index)
+	return nil
+}
+
+// ResolveRule returns the rule named name, or the default months rule in
+// mode when name is empty. mode only applies to the default rule; empty
+// means gap.
+func (c *Controller) ResolveRule(name string, months int, mode string) (rules.Rule, error) {
+	if name == "" || name == rules.DefaultName {
+		if mode == "" {
+			return rules.Default(months, rules.ModeGap), nil
+		}
+		m, err := rules.ParseMode(mode)
+		if err != nil {
+			return rules.Rule{}, err
+		}
+		return rules.Default(months, m), nil
+	}
+	if mode != "" {
+		return rules.Rule{}, fmt.Errorf("mode only applies to the %s rule", rules.DefaultName)
+	}
+	r, ok := c.rules.Get(name)
+	if !ok {
+		return rules.Rule{}, fmt.Errorf("unknown rule %q", name)
+	}
+	return r, nil
+}
+
+// Rules lists the configured rules after the default one.
+func (c *Controller) Rules(months int) []rules.Rule {
+	return append([]rules.Rule{rules.Default(months, rules.ModeGap)}, c.rules.List()...)
+}
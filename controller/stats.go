@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"action_users/models"
+	"action_users/rules"
+)
+
+// statsPageSize is how many users with actions are read per round of the
+// statistics scan.
+const statsPageSize = 1000
+
+// unknownKey labels users whose dimension value is missing.
+const unknownKey = "unknown"
+
+// noActionKey is the last-action type of registered clients without actions.
+const noActionKey = "NONE"
+
+// StatsOptions select the population of the statistics endpoints. Inactive
+// means matching Rule at AsOf, the default gap rule over Months when Rule
+// is unset. Only the two latest actions of a user are known to the
+// statistics, so Rule may only have conditions on any action.
+type StatsOptions struct {
+	Countries models.Countries
+	Months    int
+	Rule      rules.Rule
+	AsOf      time.Time
+}
+
+func (o StatsOptions) rule() rules.Rule {
+	if len(o.Rule.Conditions) == 0 {
+		return rules.Default(o.Months, rules.ModeGap)
+	}
+	return o.Rule
+}
+
+func (o StatsOptions) until() int64 {
+	if o.AsOf.IsZero() {
+		return 0
+	}
+	return o.AsOf.Unix()
+}
+
+// StatsRow is one bucket of a breakdown.
+type StatsRow struct {
+	Key                 string `json:"key"`
+	Name                string `json:"name,omitempty"`
+	Inactive            int    `json:"inactive"`
+	Orphan              int    `json:"orphan"`
+	RegisteredNoActions int    `json:"registeredNoActions"`
+}
+
+// InactivityStats are the inactivity totals of a population and their
+// breakdowns. Inactive and orphan users are split by their last action type;
+// registered clients without actions fall under NONE.
+type InactivityStats struct {
+	Months           int              `json:"months"`
+	Rule             rules.Rule       `json:"rule"`
+	AsOf             int64            `json:"asOf,omitempty"`
+	Countries        models.Countries `json:"countries"`
+	UsersWithActions int              `json:"usersWithActions"`
+	Active           int              `json:"active"`
+	Totals           StatsRow         `json:"totals"`
+	ByCountry        []StatsRow       `json:"byCountry"`
+	ByPlatform       []StatsRow       `json:"byPlatform"`
+	ByCohort         []StatsRow       `json:"byCohort"`
+	ByLastActionType []StatsRow       `json:"byLastActionType"`
+}
+
+// breakdown accumulates StatsRows by key.
+type breakdown map[string]*StatsRow
+
+func (b breakdown) row(key string) *StatsRow {
+	r, ok := b[key]
+	if !ok {
+		r = &StatsRow{Key: key}
+		b[key] = r
+	}
+	return r
+}
+
+// rows lists the buckets ordered by key, numerically when keys are numbers,
+// with unknownKey and noActionKey last.
+func (b breakdown) rows(name func(key string) string) []StatsRow {
+	out := make([]StatsRow, 0, len(b))
+	for _, r := range b {
+		if r.Inactive == 0 && r.Orphan == 0 && r.RegisteredNoActions == 0 {
+			continue
+		}
+		if name != nil {
+			r.Name = name(r.Key)
+		}
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ki, kj := out[i].Key, out[j].Key
+		si, sj := ki == unknownKey || ki == noActionKey, kj == unknownKey || kj == noActionKey
+		if si != sj {
+			return sj
+		}
+		ni, ei := strconv.Atoi(ki)
+		nj, ej := strconv.Atoi(kj)
+		if ei == nil && ej == nil {
+			return ni < nj
+		}
+		return ki < kj
+	})
+	return out
+}
+
+func intKey(v int) string {
+	if v == 0 {
+		return unknownKey
+	}
+	return strconv.Itoa(v)
+}
+
+// cohortKey is the breakdown key of a registration month as counted in a
+// models.ClientBreakdown, where clients without createdAt have none.
+func cohortKey(month string) string {
+	if month == "" {
+		return unknownKey
+	}
+	return month
+}
+
+// clientProfile is the part of a client document the statistics group by.
+type clientProfile struct {
+	countryId int
+	platform  int
+	createdAt int64
+}
+
+func profileOf(source map[string]interface{}) clientProfile {
+	var p clientProfile
+	if user, ok := source["user"].(map[string]interface{}); ok {
+		if v, ok := user["countryId"].(float64); ok {
+			p.countryId = int(v)
+		}
+	}
+	p.createdAt, _ = registeredAt(source)
+	if stats, ok := source["stats"].(map[string]interface{}); ok {
+		if v, ok := stats["platform"].(float64); ok {
+			p.platform = int(v)
+		}
+	}
+	return p
+}
+
+// statsGroup is a set of users of one page of the statistics scan that
+// share what the action indices say about them: whether their two latest
+// actions make them inactive and, for those, the type of the latest one,
+// and the country of the latest action. clients-searcher is asked about each group as a whole.
+type statsGroup struct {
+	inactive  bool
+	lastType  models.ActionType
+	countryId int
+}
+
+func (g statsGroup) key() string {
+	return fmt.Sprintf("%t/%s/%d", g.inactive, g.lastType, g.countryId)
+}
+
+// lastUserActions is what the rules need to know of the user of la: the
+// two latest actions, of which only the latest has a type and country.
+func lastUserActions(la models.LastAction) *models.UserActions {
+	ua := &models.UserActions{Recent: []models.Action{{
+		Type:      la.Type,
+		UserId:    la.UserId,
+		CountryId: la.CountryId,
+		CreatedAt: la.CreatedAt,
+	}}}
+	if la.PreviousAt != 0 {
+		ua.Recent = append(ua.Recent, models.Action{UserId: la.UserId, CreatedAt: la.PreviousAt})
+	}
+	return ua
+}
+
+// InactivityStats counts inactive, orphan and registered-without-actions
+// users by country, platform, registration month and last-action type,
+// judging inactivity with the rules ClassifyUsers applies. It pages through
+// the two latest actions of every user with an aggregation over the action
+// indices and joins each page to clients-searcher with another, group by
+// group. Registered clients are placed by their client document,
+// orphans by the country of their latest action.
+func (c *Controller) InactivityStats(opts StatsOptions) (InactivityStats, error) {
+	now := opts.AsOf
+	if now.IsZero() {
+		now = c.Now()
+	}
+	rule := opts.rule()
+	until := opts.until()
+	// The same clients ProcessUsers would have scanned.
+	scope := models.ClientFilter{Countries: opts.Countries, CreatedBefore: until}
+
+	stats := InactivityStats{
+		Months:    opts.Months,
+		Rule:      rule,
+		AsOf:      until,
+		Countries: opts.Countries,
+	}
+
+	byCountry, byPlatform, byCohort, byType := breakdown{}, breakdown{}, breakdown{}, breakdown{}
+	// Clients in scope that have actions, to subtract from the client breakdown.
+	withActions := models.ClientBreakdown{ByCountry: map[int]int{}, ByPlatform: map[int]int{}, ByCohort: map[string]int{}}
+
+	cursor := models.Cursor{}
+	for {
+		page, next, err := c.store.GetLastActionsAfter(cursor, statsPageSize, until)
+		if err != nil {
+			return stats, err
+		}
+
+		groups := map[statsGroup][]string{}
+		for _, la := range page {
+			g := statsGroup{inactive: rule.Matches(lastUserActions(la), now), countryId: la.CountryId}
+			if g.inactive {
+				g.lastType = la.Type
+			}
+			groups[g] = append(groups[g], la.UserId)
+		}
+		byKey := make(map[string][]string, len(groups))
+		for g, userIds := range groups {
+			byKey[g.key()] = userIds
+		}
+		joins, err := c.store.GetClientJoin(byKey, scope)
+		if err != nil {
+			return stats, err
+		}
+
+		for g, userIds := range groups {
+			join := joins[g.key()]
+			m := join.Matching
+			stats.UsersWithActions += m.Total
+			withActions.Total += m.Total
+			for id, n := range m.ByCountry {
+				withActions.ByCountry[id] += n
+			}
+			for id, n := range m.ByPlatform {
+				withActions.ByPlatform[id] += n
+			}
+			for month, n := range m.ByCohort {
+				withActions.ByCohort[month] += n
+			}
+
+			orphans := 0
+			if opts.Countries.Contains(g.countryId) {
+				orphans = max(len(userIds)-join.Registered, 0)
+			}
+			stats.UsersWithActions += orphans
+
+			if !g.inactive {
+				stats.Active += m.Total
+				continue
+			}
+
+			typeKey := string(g.lastType)
+			if typeKey == "" {
+				typeKey = unknownKey
+			}
+			stats.Totals.Inactive += m.Total
+			byType.row(typeKey).Inactive += m.Total
+			for id, n := range m.ByCountry {
+				byCountry.row(intKey(id)).Inactive += n
+			}
+			for id, n := range m.ByPlatform {
+				byPlatform.row(intKey(id)).Inactive += n
+			}
+			for month, n := range m.ByCohort {
+				byCohort.row(cohortKey(month)).Inactive += n
+			}
+
+			stats.Totals.Orphan += orphans
+			byType.row(typeKey).Orphan += orphans
+			byCountry.row(intKey(g.countryId)).Orphan += orphans
+			byPlatform.row(unknownKey).Orphan += orphans
+			byCohort.row(unknownKey).Orphan += orphans
+		}
+
+		if next == nil {
+			break
+		}
+		cursor = *next
+	}
+
+	all, err := c.store.GetClientBreakdown(scope)
+	if err != nil {
+		return stats, err
+	}
+	stats.Totals.RegisteredNoActions = max(all.Total-withActions.Total, 0)
+	byType.row(noActionKey).RegisteredNoActions = stats.Totals.RegisteredNoActions
+	for id, n := range all.ByCountry {
+		byCountry.row(intKey(id)).RegisteredNoActions += max(n-withActions.ByCountry[id], 0)
+	}
+	for id, n := range all.ByPlatform {
+		byPlatform.row(intKey(id)).RegisteredNoActions += max(n-withActions.ByPlatform[id], 0)
+	}
+	for month, n := range all.ByCohort {
+		byCohort.row(cohortKey(month)).RegisteredNoActions += max(n-withActions.ByCohort[month], 0)
+	}
+
+	stats.Totals.Key = "total"
+	stats.ByCountry = byCountry.rows(func(key string) string {
+		id, _ := strconv.Atoi(key)
+		return c.ref.CountryName(id)
+	})
+	stats.ByPlatform = byPlatform.rows(nil)
+	stats.ByCohort = byCohort.rows(nil)
+	stats.ByLastActionType = byType.rows(nil)
+	return stats, nil
+}
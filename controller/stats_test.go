@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"action_users/models"
+	"action_users/rules"
+)
+
+func TestInactivityStats(t *testing.T) {
+	now := date(2024, time.April, 15)
+	var f testFixtures
+	f.client(1, 213) // inactive, betting from Uzbekistan
+	f.client(2, 213) // no actions
+	f.client(5, 233) // active, betting from Tajikistan
+	f.bet(1, 233, date(2023, time.December, 1))
+	f.bet(3, 213, date(2023, time.November, 1)) // orphan
+	f.bet(4, 233, date(2023, time.October, 1))  // orphan
+	f.bet(5, 213, date(2024, time.April, 1))
+	c := newTestController(f.store(), now)
+
+	tests := []struct {
+		name             string
+		countries        models.Countries
+		usersWithActions int
+		active           int
+		totals           StatsRow
+		byCountry        []StatsRow
+		byLastActionType []StatsRow
+	}{
+		{
+			name:             "all countries",
+			usersWithActions: 4,
+			active:           1,
+			totals:           StatsRow{Key: "total", Inactive: 1, Orphan: 2, RegisteredNoActions: 1},
+			byCountry: []StatsRow{
+				{Key: "213", Name: "Tajikistan", Inactive: 1, Orphan: 1, RegisteredNoActions: 1},
+				{Key: "233", Name: "Uzbekistan", Orphan: 1},
+			},
+			byLastActionType: []StatsRow{
+				{Key: "BET", Inactive: 1, Orphan: 2},
+				{Key: "NONE", RegisteredNoActions: 1},
+			},
+		},
+		{
+			// Registered clients are scoped by their client document and
+			// orphans by the country of their latest action, so client 1
+			// is inactive rather than registered without actions.
+			name:             "country of the client document",
+			countries:        models.SingleCountry(213),
+			usersWithActions: 2,
+			totals:           StatsRow{Key: "total", Inactive: 1, Orphan: 1, RegisteredNoActions: 1},
+			byCountry: []StatsRow{
+				{Key: "213", Name: "Tajikistan", Inactive: 1, Orphan: 1, RegisteredNoActions: 1},
+			},
+			byLastActionType: []StatsRow{
+				{Key: "BET", Inactive: 1, Orphan: 1},
+				{Key: "NONE", RegisteredNoActions: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := c.InactivityStats(StatsOptions{Countries: tt.countries, Months: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.UsersWithActions != tt.usersWithActions || stats.Active != tt.active {
+				t.Errorf("usersWithActions = %d, active = %d, want %d, %d", stats.UsersWithActions, stats.Active, tt.usersWithActions, tt.active)
+			}
+			if stats.Totals != tt.totals {
+				t.Errorf("totals = %+v, want %+v", stats.Totals, tt.totals)
+			}
+			assertRows(t, "byCountry", stats.ByCountry, tt.byCountry)
+			assertRows(t, "byLastActionType", stats.ByLastActionType, tt.byLastActionType)
+		})
+	}
+}
+
+func assertRows(t *testing.T, name string, got, want []StatsRow) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s = %+v, want %+v", name, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s[%d] = %+v, want %+v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestInactivityStatsModes(t *testing.T) {
+	now := date(2024, time.April, 15)
+	var f testFixtures
+	f.client(1, 213) // two bets weeks apart, the latter over 3 months ago
+	f.client(2, 213) // a bet half a year before a recent one
+	f.client(3, 213) // as 1, a month earlier
+	f.bet(1, 213, date(2023, time.December, 1))
+	f.bet(1, 213, date(2023, time.December, 20))
+	f.bet(2, 213, date(2023, time.October, 1))
+	f.bet(2, 213, date(2024, time.April, 1))
+	f.bet(3, 213, date(2023, time.November, 1))
+	f.bet(3, 213, date(2023, time.November, 20))
+	c := newTestController(f.store(), now)
+
+	tests := []struct {
+		mode     rules.Mode
+		inactive int
+		active   int
+	}{
+		{rules.ModeGap, 1, 2},
+		{rules.ModeSinceLast, 2, 1},
+		{rules.ModeBoth, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			stats, err := c.InactivityStats(StatsOptions{Months: 3, Rule: rules.Default(3, tt.mode)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Totals.Inactive != tt.inactive || stats.Active != tt.active {
+				t.Errorf("inactive = %d, active = %d, want %d, %d", stats.Totals.Inactive, stats.Active, tt.inactive, tt.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"slices"
+	"sort"
+
+	"action_users/churn"
+	"action_users/constants"
+	"action_users/models"
+	"action_users/rules"
+)
+
+// Lookups an index trace can report: LookupAll when no country was selected,
+// otherwise LookupCountry or the LookupNoCountry fallback.
+const (
+	LookupAll       = "all"
+	LookupCountry   = "country"
+	LookupNoCountry = "noCountry"
+)
+
+// IndexTrace is what one action index contributed to a classification.
+// Lookup is "noCountry" when the country-filtered search came back empty
+// and the actions were found without the filter.
+type IndexTrace struct {
+	Index   string            `json:"index"`
+	Type    models.ActionType `json:"type"`
+	Lookup  string            `json:"lookup"`
+	Actions []models.Action   `json:"actions"`
+}
+
+// StatusTrace explains how UserStatus arrived at its segment.
+type StatusTrace struct {
+	Countries   models.Countries `json:"countries"`
+	AsOf        int64            `json:"asOf,omitempty"`
+	Indices     []IndexTrace     `json:"indices"`
+	Recent      []models.Action  `json:"recent"`
+	ClientFound bool             `json:"clientFound"`
+	Rule        rules.Rule       `json:"rule"`
+	Conditions  []rules.Outcome  `json:"conditions"`
+	Inactive    bool             `json:"inactive"`
+	Reasons     []string         `json:"reasons,omitempty"`
+	Churn       *churn.Features  `json:"churn,omitempty"`
+}
+
+// UserStatus is the classification of a single user with its trace.
+type UserStatus struct {
+	ClientData models.ClientData `json:"clientData"`
+	Trace      StatusTrace       `json:"trace"`
+}
+
+// GetUserStatus classifies one user exactly as ClassifyUsers would inside a
+// page and records how: the actions found per index, whether the country
+// filter had to be dropped, and the outcome of each condition of the rule.
+// found is false when the user has neither actions nor a client document.
+func (c *Controller) GetUserStatus(userId string, opts ClassifyOptions) (status UserStatus, found bool, err error) {
+	now := opts.AsOf
+	if now.IsZero() {
+		now = c.Now()
+	}
+
+	byUser, err := c.lastActionsByIndex([]string{userId}, opts.Countries, opts.Until())
+	if err != nil {
+		return status, false, err
+	}
+	byIndex := byUser[userId]
+	ua := userActionsFromIndices(byIndex)
+
+	cl, err := c.classifyUser(userId, ua, opts, now, true)
+	if err != nil {
+		return status, false, err
+	}
+
+	uas := map[string]*models.UserActions{userId: ua}
+	ranges := pageRanges(now, opts.moneyWindow())
+	stats, err := c.actionStats(usersWithActions(uas), ranges)
+	if err != nil {
+		return status, false, err
+	}
+	f, scored := churnFeatures(uas, stats, now)[userId]
+	if scored {
+		scoreChurn(&cl.Data, f)
+		cl.Data.Monetary = c.monetary(stats[userId], ranges[rangeWindow].From)
+	}
+
+	status = UserStatus{
+		ClientData: cl.Data,
+		Trace: StatusTrace{
+			Countries:   opts.Countries,
+			AsOf:        opts.Until(),
+			Indices:     traceIndices(byIndex, opts.Countries),
+			Recent:      []models.Action{},
+			ClientFound: cl.ClientFound,
+			Rule:        opts.Rule,
+			Conditions:  opts.Rule.Explain(ua, now),
+			Inactive:    cl.Inactive,
+			Reasons:     cl.Reasons,
+		},
+	}
+	if ua != nil {
+		status.Trace.Recent = ua.Recent
+	}
+	if scored {
+		status.Trace.Churn = &f
+	}
+	return status, cl.Data.Segment != "", nil
+}
+
+// traceIndices reports the actions each index gave the user. The lookup
+// is told from the actions themselves: the fallback without the country
+// filter only runs when the filtered search found nothing, so it can only
+// have found actions outside the selection.
+func traceIndices(byIndex map[string][]models.Action, countries models.Countries) []IndexTrace {
+	traces := make([]IndexTrace, 0, len(constants.Indices))
+	for index := range constants.Indices {
+		it := IndexTrace{Index: index, Type: constants.ActionTypes[index], Lookup: LookupAll, Actions: byIndex[index]}
+		if !countries.IsAll() {
+			it.Lookup = LookupCountry
+			if len(it.Actions) > 0 && !slices.ContainsFunc(it.Actions, func(a models.Action) bool {
+				return countries.Contains(a.CountryId)
+			}) {
+				it.Lookup = LookupNoCountry
+			}
+		}
+		if it.Actions == nil {
+			it.Actions = []models.Action{}
+		}
+		traces = append(traces, it)
+	}
+
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].Index < traces[j].Index
+	})
+	return traces
+}
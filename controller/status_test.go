@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/models"
+	"action_users/rules"
+)
+
+func TestGetUserStatusLookups(t *testing.T) {
+	c := newTestController(loadFixtures(t), date(2026, time.October, 17))
+	countries := models.SingleCountry(213)
+	opts := ClassifyOptions{Countries: countries, Months: 3, Rule: rules.Default(3, rules.ModeGap)}
+
+	uas, err := c.GetLastActionsForUsers([]string{"1001", "1002"}, countries, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		userId string
+		found  []string
+		lookup string
+	}{
+		{"1001", []string{"client_bets-searcher", "client_online_top_ups-searcher"}, LookupCountry},
+		// 1002 is in 233 and only turns up without the country filter.
+		{"1002", []string{"client_bets-searcher", "client_online_top_ups-searcher"}, LookupNoCountry},
+	}
+	for _, tt := range tests {
+		status, found, err := c.GetUserStatus(tt.userId, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatalf("%s: not found", tt.userId)
+		}
+		if !slices.Equal(status.Trace.Recent, uas[tt.userId].Recent) {
+			t.Errorf("%s: Recent = %+v, want %+v as GetLastActionsForUsers", tt.userId, status.Trace.Recent, uas[tt.userId].Recent)
+		}
+		for _, it := range status.Trace.Indices {
+			lookup := LookupCountry
+			if slices.Contains(tt.found, it.Index) {
+				lookup = tt.lookup
+			} else if len(it.Actions) != 0 {
+				t.Errorf("%s: %s has %d actions, want none", tt.userId, it.Index, len(it.Actions))
+			}
+			if it.Lookup != lookup {
+				t.Errorf("%s: %s lookup = %s, want %s", tt.userId, it.Index, it.Lookup, lookup)
+			}
+		}
+	}
+}
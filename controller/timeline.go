@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"action_users/constants"
+	"action_users/models"
+)
+
+// MaxTimelineWindow is the default max_result_window of the action indices:
+// no index can be asked for more than this many actions of a user.
+const MaxTimelineWindow = 10000
+
+// TimelineQuery selects a page of a user's timeline. Types limits the feed to
+// some action types; an empty list means all of them.
+type TimelineQuery struct {
+	Types  []models.ActionType
+	Range  models.ActionRange
+	Offset int
+	Limit  int
+}
+
+func (q TimelineQuery) wants(t models.ActionType) bool {
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, want := range q.Types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserTimeline merges the actions of a user from every index in
+// constants.Indices, newest first, and returns the requested page together
+// with whether more actions follow it. Offset+Limit must not exceed
+// MaxTimelineWindow; a page ending at the window reports more actions when
+// any index may hold more than it returned.
+func (c *Controller) GetUserTimeline(userId string, q TimelineQuery) ([]models.Action, bool, error) {
+	// Every index has to contribute enough actions to fill the page on its
+	// own, plus one to tell whether there is a next page, but never more
+	// than the window.
+	size := min(q.Offset+q.Limit+1, MaxTimelineWindow)
+	truncated := false
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	all := []models.Action{}
+
+	for idx := range constants.Indices {
+		if !q.wants(constants.ActionTypes[idx]) {
+			continue
+		}
+		wg.Add(1)
+		go func(index string) {
+			defer wg.Done()
+			acts, err := c.store.GetActionsInRange(userId, index, size, q.Range)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", index, err)
+				}
+				return
+			}
+			if len(acts) == size && size < q.Offset+q.Limit+1 {
+				truncated = true
+			}
+			all = append(all, acts...)
+		}(idx)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, false, firstErr
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].CreatedAt != all[j].CreatedAt {
+			return all[i].CreatedAt > all[j].CreatedAt
+		}
+		if all[i].Index != all[j].Index {
+			return all[i].Index < all[j].Index
+		}
+		return all[i].Id < all[j].Id
+	})
+
+	if q.Offset >= len(all) {
+		return []models.Action{}, false, nil
+	}
+	page := all[q.Offset:]
+	hasMore := len(page) > q.Limit || truncated
+	if hasMore {
+		page = page[:q.Limit]
+	}
+	return page, hasMore, nil
+}
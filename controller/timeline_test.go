@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"action_users/models"
+	"action_users/repositories"
+)
+
+// sizeRecordingStore records the largest page size asked of the action
+// indices, which OpenSearch rejects above max_result_window. GetUserTimeline
+// reads the indices concurrently, hence the lock.
+type sizeRecordingStore struct {
+	*repositories.MemoryStore
+	mu      sync.Mutex
+	maxSize int
+}
+
+func (s *sizeRecordingStore) GetActionsInRange(userId string, index string, size int, r models.ActionRange) ([]models.Action, error) {
+	s.mu.Lock()
+	s.maxSize = max(s.maxSize, size)
+	s.mu.Unlock()
+	return s.MemoryStore.GetActionsInRange(userId, index, size, r)
+}
+
+func TestGetUserTimelineWindowBoundary(t *testing.T) {
+	var f testFixtures
+	for i := range 3 {
+		f.bet(1001, 213, date(2024, time.January, 1+i))
+	}
+	store := &sizeRecordingStore{MemoryStore: f.store()}
+	c := newTestController(store, date(2024, time.April, 15))
+
+	tests := []struct {
+		name        string
+		offset      int
+		limit       int
+		wantActions int
+		wantMore    bool
+	}{
+		{name: "first page", offset: 0, limit: 2, wantActions: 2, wantMore: true},
+		{name: "last page", offset: 2, limit: 2, wantActions: 1, wantMore: false},
+		{name: "page ending at the window", offset: MaxTimelineWindow - 100, limit: 100, wantActions: 0, wantMore: false},
+		{name: "single action at the window", offset: MaxTimelineWindow - 1, limit: 1, wantActions: 0, wantMore: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.maxSize = 0
+			actions, hasMore, err := c.GetUserTimeline("1001", TimelineQuery{Offset: tt.offset, Limit: tt.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if store.maxSize > MaxTimelineWindow {
+				t.Errorf("asked an index for %d actions, more than the window of %d", store.maxSize, MaxTimelineWindow)
+			}
+			if len(actions) != tt.wantActions || hasMore != tt.wantMore {
+				t.Errorf("got %d actions, hasMore %t, want %d, %t", len(actions), hasMore, tt.wantActions, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestGetUserTimelineTruncatedAtWindow(t *testing.T) {
+	var f testFixtures
+	for i := range MaxTimelineWindow + 1 {
+		f.bet(1001, 213, date(2020, time.January, 1).Add(time.Duration(i)*time.Second))
+	}
+	store := &sizeRecordingStore{MemoryStore: f.store()}
+	c := newTestController(store, date(2024, time.April, 15))
+
+	actions, hasMore, err := c.GetUserTimeline("1001", TimelineQuery{Offset: MaxTimelineWindow - 10, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.maxSize != MaxTimelineWindow {
+		t.Errorf("asked an index for %d actions, want the window of %d", store.maxSize, MaxTimelineWindow)
+	}
+	if len(actions) != 10 || !hasMore {
+		t.Errorf("got %d actions, hasMore %t, want 10 and more beyond the window", len(actions), hasMore)
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxBatchUserIds caps how many users one batch request may classify.
+	// The whole list is classified while the client waits, so anything
+	// larger belongs in a job.
+	maxBatchUserIds = 5000
+
+	// batchPageSize is how many of the posted users are classified per
+	// round of action lookups.
+	batchPageSize = 1000
+)
+
+// ProcessUsersBatch classifies the posted userIds instead of paging through
+// clients-searcher. The body is a JSON array of ids or a newline separated
+// list, either raw or uploaded as the multipart field "file". Query
+// parameters are those of ProcessUsers except page, limit and cursor.
+func (h *Handler) ProcessUsersBatch(c *fiber.Ctx) error {
+	opts, _, err := h.segmentParams(c, "1")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	body := c.Body()
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "failed to read uploaded file",
+			})
+		}
+		body, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "failed to read uploaded file",
+			})
+		}
+	}
+
+	userIds, duplicates, err := parseUserIds(body)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(userIds) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "no userIds in request body",
+		})
+	}
+	if len(userIds) > maxBatchUserIds {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("too many userIds: %d (max %d), use POST /jobs/process-users for full runs", len(userIds), maxBatchUserIds),
+		})
+	}
+
+	log.Printf("info: processing batch of %d users (months %d, countries %+v)", len(userIds), opts.Months, opts.Countries)
+
+	segments, err := h.ctrl.ClassifyBatch(userIds, opts, batchPageSize)
+	if err != nil {
+		log.Printf("error: classifyBatch failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch user actions",
+		})
+	}
+
+	var persistedTo string
+	if c.QueryBool("persist") {
+		persistedTo = h.ctrl.SegmentsIndex(opts)
+		if err := h.ctrl.SaveSegments(persistedTo, segments, opts); err != nil {
+			log.Printf("error: saveSegments failed: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to persist segments",
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"orphanUsers":         segments.Orphan,
+		"inactiveUsers":       segments.Inactive,
+		"registeredNoActions": segments.RegisteredNoActions,
+		"atRiskUsers":         segments.AtRisk,
+		"summary": fiber.Map{
+			"orphanUsersCount":         len(segments.Orphan),
+			"inactiveUsersCount":       len(segments.Inactive),
+			"registeredNoActionsCount": len(segments.RegisteredNoActions),
+			"atRiskUsersCount":         len(segments.AtRisk),
+			"totalProcessed":           len(userIds),
+			"duplicatesSkipped":        duplicates,
+			"months":                   opts.Months,
+			"countryId":                opts.Countries.Single(),
+			"countries":                opts.Countries,
+			"rule":                     opts.Rule.Name,
+			"minRisk":                  opts.MinRisk,
+			"monetary":                 opts.Monetary,
+			"asOf":                     asOfUnix(opts.AsOf),
+			"persistedTo":              persistedTo,
+		},
+	})
+}
+
+// parseUserIds reads a JSON array of ids, given as numbers or strings, or
+// one id per line. Blank lines are ignored and repeated ids are kept once,
+// in the order they first appear.
+func parseUserIds(body []byte) ([]string, int, error) {
+	var raw []string
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		var items []interface{}
+		if err := dec.Decode(&items); err != nil {
+			return nil, 0, fmt.Errorf("invalid JSON array of userIds: %v", err)
+		}
+		for _, item := range items {
+			switch v := item.(type) {
+			case json.Number:
+				raw = append(raw, v.String())
+			case string:
+				raw = append(raw, v)
+			default:
+				return nil, 0, fmt.Errorf("invalid userId %v: must be a number or a string", item)
+			}
+		}
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(trimmed))
+		for sc.Scan() {
+			raw = append(raw, sc.Text())
+		}
+		if err := sc.Err(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	seen := make(map[string]bool, len(raw))
+	userIds := make([]string, 0, len(raw))
+	duplicates := 0
+	for i, id := range raw {
+		id = strings.TrimSpace(strings.TrimSuffix(id, ","))
+		if id == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("invalid userId %q at position %d", id, i+1)
+		}
+		if seen[id] {
+			duplicates++
+			continue
+		}
+		seen[id] = true
+		userIds = append(userIds, id)
+	}
+	return userIds, duplicates, nil
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"action_users/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+
+	// exportChunkTimeout bounds each streamed chunk instead of the whole
+	// response, which would otherwise be cut off by the server WriteTimeout.
+	exportChunkTimeout = 30 * time.Second
+)
+
+var csvHeader = []string{
+	"segment", "userId", "login", "firstName", "lastName", "phone", "countryId", "countryName", "platform", "state",
+	"createdAt", "activeWallet", "balance", "currencyId", "currencyCode", "wallets", "totalBalance", "totalCurrency",
+	"lastTopUp", "lastBet", "lastWithdrawal",
+	"lastActivity", "reactivationThreshold", "canReactivate", "churnScore", "riskBand",
+	"moneyCurrency", "deposited", "wagered", "withdrawn", "netRevenue", "averageBet",
+	"windowFrom", "windowDeposited", "windowWagered", "windowWithdrawn", "windowNetRevenue", "windowAverageBet",
+}
+
+func csvRow(cd models.ClientData) []string {
+	active, _ := cd.Account.ActiveWallet()
+	return append([]string{
+		cd.Segment,
+		cd.UserId,
+		cd.Login,
+		cd.FirstName,
+		cd.LastName,
+		cd.Phone,
+		strconv.Itoa(cd.CountryId),
+		cd.CountryName,
+		strconv.Itoa(cd.Platform),
+		strconv.Itoa(cd.State),
+		strconv.FormatInt(cd.CreatedAt, 10),
+		active.Number,
+		strconv.FormatFloat(active.Balance, 'f', -1, 64),
+		strconv.Itoa(cd.Account.CurrencyId),
+		cd.CurrencyCode,
+		strconv.Itoa(len(cd.Account.Wallets)),
+		strconv.FormatFloat(cd.Account.TotalBalance, 'f', -1, 64),
+		cd.Account.TotalCurrency,
+		strconv.FormatInt(cd.LastTopUp, 10),
+		strconv.FormatInt(cd.LastBet, 10),
+		strconv.FormatInt(cd.LastWithdrawal, 10),
+		strconv.FormatInt(cd.LastActivity, 10),
+		strconv.FormatInt(cd.ReactivationThreshold, 10),
+		strconv.FormatBool(cd.CanReactivate),
+		strconv.FormatFloat(cd.ChurnScore, 'f', -1, 64),
+		cd.RiskBand,
+	}, moneyColumns(cd.Monetary)...)
+}
+
+// moneyColumns are the monetary columns of csvRow, empty without totals.
+func moneyColumns(m *models.Monetary) []string {
+	if m == nil {
+		return make([]string, 12)
+	}
+	cols := []string{m.Currency}
+	for i, t := range []models.MoneyTotals{m.Lifetime, m.Window} {
+		if i == 1 {
+			cols = append(cols, strconv.FormatInt(m.WindowFrom, 10))
+		}
+		cols = append(cols,
+			strconv.FormatFloat(t.Deposited, 'f', -1, 64),
+			strconv.FormatFloat(t.Wagered, 'f', -1, 64),
+			strconv.FormatFloat(t.Withdrawn, 'f', -1, 64),
+			strconv.FormatFloat(t.NetRevenue, 'f', -1, 64),
+			strconv.FormatFloat(t.AverageBet, 'f', -1, 64),
+		)
+	}
+	return cols
+}
+
+// segmentRows picks the buckets requested by the segment query parameter.
+func segmentRows(segments models.Segments, segment string) []models.ClientData {
+	switch segment {
+	case models.SegmentInactive:
+		return segments.Inactive
+	case models.SegmentOrphan:
+		return segments.Orphan
+	case models.SegmentRegisteredNoActions:
+		return segments.RegisteredNoActions
+	case models.SegmentAtRisk:
+		return segments.AtRisk
+	}
+	rows := make([]models.ClientData, 0, len(segments.Inactive)+len(segments.Orphan)+len(segments.RegisteredNoActions)+len(segments.AtRisk))
+	rows = append(rows, segments.Inactive...)
+	rows = append(rows, segments.Orphan...)
+	rows = append(rows, segments.RegisteredNoActions...)
+	return append(rows, segments.AtRisk...)
+}
+
+// exportFormat reads the format query parameter, falling back to the Accept
+// header and then to NDJSON, and sets the matching Content-Type.
+func exportFormat(c *fiber.Ctx) (string, bool) {
+	format := c.Query("format")
+	if format == "" {
+		if c.Accepts("application/x-ndjson", "text/csv") == "text/csv" {
+			format = formatCSV
+		} else {
+			format = formatNDJSON
+		}
+	}
+
+	switch format {
+	case formatNDJSON:
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	case formatCSV:
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	default:
+		return "", false
+	}
+	return format, true
+}
+
+// rowWriter writes ClientData rows to w as NDJSON or CSV.
+type rowWriter struct {
+	w   *bufio.Writer
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+func newRowWriter(format string, w *bufio.Writer) (*rowWriter, error) {
+	rw := &rowWriter{w: w}
+	if format == formatCSV {
+		rw.csv = csv.NewWriter(w)
+		if err := rw.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	} else {
+		rw.enc = json.NewEncoder(w)
+	}
+	return rw, nil
+}
+
+func (rw *rowWriter) Write(cd models.ClientData) error {
+	if rw.csv != nil {
+		return rw.csv.Write(csvRow(cd))
+	}
+	return rw.enc.Encode(cd)
+}
+
+func (rw *rowWriter) Flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return rw.w.Flush()
+}
+
+// Abort marks a stream that ended early. CSV has no way to say so; NDJSON
+// gets a trailing error line.
+func (rw *rowWriter) Abort() {
+	if rw.enc != nil {
+		_ = rw.enc.Encode(fiber.Map{"error": "export aborted"})
+	}
+	_ = rw.Flush()
+}
+
+func validSegment(segment string) bool {
+	switch segment {
+	case models.SegmentInactive, models.SegmentOrphan, models.SegmentRegisteredNoActions, models.SegmentAtRisk, "all":
+		return true
+	}
+	return false
+}
+
+// ExportUsers streams the whole segment for a country as NDJSON or CSV with
+// chunked transfer encoding, one page of clients at a time, so nothing but
+// the current page is held in memory.
+func (h *Handler) ExportUsers(c *fiber.Ctx) error {
+	opts, limit, err := h.segmentParams(c, "1000")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	segment := c.Query("segment", models.SegmentInactive)
+	if !validSegment(segment) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid segment parameter (inactive, orphan, registeredNoActions, atRisk, all)",
+		})
+	}
+
+	format, ok := exportFormat(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid format parameter (ndjson, csv)",
+		})
+	}
+	filename := fmt.Sprintf("%s-users-%s.%s", segment, h.ctrl.Now().Format("2006-01-02"), format)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	var persistTo string
+	if c.QueryBool("persist") {
+		persistTo = h.ctrl.SegmentsIndex(opts)
+		c.Set("X-Segments-Index", persistTo)
+	}
+
+	log.Printf("info: exporting %s users as %s (countries %+v, months %d, rule %s)",
+		segment, format, opts.Countries, opts.Months, opts.Rule.Name)
+
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		rw, err := newRowWriter(format, w)
+		if err != nil {
+			return
+		}
+
+		exported := 0
+		err = h.ctrl.WalkSegments(opts, limit, func(_ []string, segments models.Segments) error {
+			if persistTo != "" {
+				if err := h.ctrl.SaveSegments(persistTo, segments, opts); err != nil {
+					return err
+				}
+			}
+			for _, cd := range segmentRows(segments, segment) {
+				if err := rw.Write(cd); err != nil {
+					return err
+				}
+				exported++
+			}
+			if err := conn.SetWriteDeadline(time.Now().Add(exportChunkTimeout)); err != nil {
+				return err
+			}
+			return rw.Flush()
+		})
+		if err != nil {
+			log.Printf("error: export of %s users aborted after %d rows: %v", segment, exported, err)
+			rw.Abort()
+			return
+		}
+		log.Printf("info: exported %d %s users", exported, segment)
+	})
+
+	return nil
+}
@@ -1,69 +1,102 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
-	"action_users/constants"
+	"action_users/churn"
 	"action_users/controller"
+	"action_users/jobs"
 	"action_users/models"
+	"action_users/pool"
 	"action_users/repositories"
+	"action_users/rules"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type Handler struct {
 	ctrl *controller.Controller
+	sem  *pool.Semaphore
+	jobs *jobs.Manager
 }
 
-func NewHandler(ctrl *controller.Controller) *Handler {
-	return &Handler{ctrl: ctrl}
+func NewHandler(ctrl *controller.Controller, sem *pool.Semaphore, jobManager *jobs.Manager) *Handler {
+	return &Handler{ctrl: ctrl, sem: sem, jobs: jobManager}
 }
 
 func (h *Handler) ProcessUsers(c *fiber.Ctx) error {
-	monthsStr := c.Query("months", "1")
-	countryIdStr := c.Query("countryId", "0")
-	pageStr := c.Query("page", "1")
-	limitStr := c.Query("limit", "50")
-
-	months, err := strconv.Atoi(monthsStr)
-	if err != nil || months < 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "invalid months parameter",
-		})
-	}
-
-	countryId, err := strconv.Atoi(countryIdStr)
-	if err != nil || countryId < 0 {
+	opts, limit, err := h.segmentParams(c, "50")
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "invalid countryId parameter",
+			"error": err.Error(),
 		})
 	}
+	months := opts.Months
 
-	page, err := strconv.Atoi(pageStr)
+	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page < 1 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "invalid page parameter",
 		})
 	}
 
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 || limit > 1000 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "invalid limit parameter (max 1000)",
-		})
-	}
+	cursorToken := c.Query("cursor")
+	useCursor := cursorToken != "" || c.Query("pagination") == "cursor"
 
-	from := (page - 1) * limit
+	var userIds []string
+	var next *models.Cursor
+	var nextCursor string
+	if useCursor {
+		scope := models.CursorScope{
+			Filter: opts.ClientFilter(),
+			Months: months,
+			Rule:   opts.Rule.Name,
+			Mode:   c.Query("mode"),
+			AsOf:   opts.Until(),
+		}
 
-	userIds, err := repositories.GetUserIds(h.ctrl.Client(), from, limit, countryId)
-	if err != nil {
-		log.Printf("error: getUserIds failed: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "failed to fetch user IDs",
-		})
+		var cursor models.Cursor
+		if cursorToken != "" {
+			cursor, err = repositories.DecodeCursor(cursorToken, scope)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": fmt.Sprintf("invalid cursor parameter: %v", err),
+				})
+			}
+		}
+
+		userIds, next, err = h.ctrl.GetUserIdsAfter(cursor, limit, opts.ClientFilter())
+		if err != nil {
+			log.Printf("error: getUserIdsAfter failed: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to fetch user IDs",
+			})
+		}
+		if next != nil {
+			next.Scope = &scope
+			nextCursor, err = repositories.EncodeCursor(*next)
+			if err != nil {
+				log.Printf("error: encodeCursor failed: %v", err)
+				h.ctrl.ReleaseCursor(*next)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "failed to build next cursor",
+				})
+			}
+		}
+	} else {
+		from := (page - 1) * limit
+		userIds, err = h.ctrl.GetUserIds(from, limit, opts.ClientFilter())
+		if err != nil {
+			log.Printf("error: getUserIds failed: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to fetch user IDs",
+			})
+		}
 	}
 
 	if len(userIds) == 0 {
@@ -73,7 +106,9 @@ func (h *Handler) ProcessUsers(c *fiber.Ctx) error {
 				"orphanUsersCount":         0,
 				"inactiveUsersCount":       0,
 				"registeredNoActionsCount": 0,
+				"atRiskUsersCount":         0,
 				"months":                   months,
+				"nextCursor":               nextCursor,
 			},
 		})
 	}
@@ -81,105 +116,236 @@ func (h *Handler) ProcessUsers(c *fiber.Ctx) error {
 	log.Printf("info: processing %d users (page %d, limit %d, months %d)",
 		len(userIds), page, limit, months)
 
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-	var inactiveResults []models.ClientData
-	var registeredNoActions []models.ClientData
-	var orphanUsers []models.ClientData
-
-	for _, uid := range userIds {
-		wg.Add(1)
-		go func(uid string) {
-			defer wg.Done()
-
-			actions, err := h.ctrl.GetLastTwoActionsForUser(uid, countryId)
-			if err != nil {
-				log.Printf("warn: getLastTwoActionsForUser(%s) error: %v", uid, err)
-				return
-			}
-
-			if len(actions) == 0 {
-				clientHit, err := repositories.GetClientById(h.ctrl.Client(), uid, countryId)
-				if err != nil {
-					log.Printf("warn: getClientById(%s) error: %v", uid, err)
-					return
-				}
-				if clientHit == nil {
-					log.Printf("warn: registered user not found AND no actions for userId: %s", uid)
-					return
-				}
-				cd := h.ctrl.BuildClientData(clientHit, nil, nil, nil, countryId, uid, actions, 0)
-				mu.Lock()
-				registeredNoActions = append(registeredNoActions, cd)
-				mu.Unlock()
-				return
-			}
+	segments, err := h.ctrl.ClassifyUsers(userIds, opts)
+	if err != nil {
+		log.Printf("error: classifyUsers failed: %v", err)
+		// The client never sees nextCursor, so nothing would walk the
+		// point in time on to its release.
+		if next != nil {
+			h.ctrl.ReleaseCursor(*next)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch user actions",
+		})
+	}
 
-			isInactive := h.ctrl.CheckUserActionsInterval(actions, months)
-			log.Printf("debug: user %s - actions: %d, isInactive: %t (months=%d)", uid, len(actions), isInactive, months)
-
-			if isInactive {
-				clientHit, err := repositories.GetClientById(h.ctrl.Client(), uid, countryId)
-				if err != nil {
-					log.Printf("warn: getClientById(%s) error: %v", uid, err)
-					return
-				}
-
-				topUpSrc, _ := h.ctrl.GetLastActionFromIndices(uid, constants.TopUpIndices, countryId)
-				betSrc, _ := h.ctrl.GetLastActionFromIndices(uid, constants.BetIndices, countryId)
-				withdrawalSrc, _ := h.ctrl.GetLastActionFromIndices(uid, constants.WithdrawalIndices, countryId)
-
-				if clientHit == nil {
-					log.Printf("info: orphan user %s - no client data but has actions", uid)
-					cd := h.ctrl.BuildClientData(nil, topUpSrc, betSrc, withdrawalSrc, countryId, uid, actions, months)
-					mu.Lock()
-					orphanUsers = append(orphanUsers, cd)
-					mu.Unlock()
-					return
-				}
-
-				cd := h.ctrl.BuildClientData(clientHit, topUpSrc, betSrc, withdrawalSrc, countryId, uid, actions, months)
-
-				if cd.ReactivationThreshold > 0 {
-					thresholdDate := time.Unix(cd.ReactivationThreshold, 0)
-					lastActivityDate := time.Unix(cd.LastActivity, 0)
-					log.Printf("info: user %s became inactive, last activity: %s, reactivation threshold: %s (months=%d)",
-						uid, lastActivityDate.Format("2006-01-02"), thresholdDate.Format("2006-01-02"), months)
-				}
-
-				mu.Lock()
-				inactiveResults = append(inactiveResults, cd)
-				mu.Unlock()
+	var persistedTo string
+	if c.QueryBool("persist") {
+		persistedTo = h.ctrl.SegmentsIndex(opts)
+		if err := h.ctrl.SaveSegments(persistedTo, segments, opts); err != nil {
+			log.Printf("error: saveSegments failed: %v", err)
+			if next != nil {
+				h.ctrl.ReleaseCursor(*next)
 			}
-		}(uid)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to persist segments",
+			})
+		}
 	}
-	wg.Wait()
-
-	log.Printf("info: processed %d users: %d inactive, %d orphan, %d registered no actions (months=%d)",
-		len(userIds), len(inactiveResults), len(orphanUsers), len(registeredNoActions), months)
 
 	response := fiber.Map{
-		"orphanUsers":         orphanUsers,
-		"inactiveUsers":       inactiveResults,
-		"registeredNoActions": registeredNoActions,
+		"orphanUsers":         segments.Orphan,
+		"inactiveUsers":       segments.Inactive,
+		"registeredNoActions": segments.RegisteredNoActions,
+		"atRiskUsers":         segments.AtRisk,
 		"summary": fiber.Map{
-			"orphanUsersCount":         len(orphanUsers),
-			"inactiveUsersCount":       len(inactiveResults),
-			"registeredNoActionsCount": len(registeredNoActions),
+			"orphanUsersCount":         len(segments.Orphan),
+			"inactiveUsersCount":       len(segments.Inactive),
+			"registeredNoActionsCount": len(segments.RegisteredNoActions),
+			"atRiskUsersCount":         len(segments.AtRisk),
 			"totalProcessed":           len(userIds),
 			"page":                     page,
 			"limit":                    limit,
 			"months":                   months,
-			"countryId":                countryId,
+			"countryId":                opts.Countries.Single(),
+			"countries":                opts.Countries,
+			"clients":                  opts.Clients,
+			"rule":                     opts.Rule.Name,
+			"minRisk":                  opts.MinRisk,
+			"monetary":                 opts.Monetary,
+			"asOf":                     asOfUnix(opts.AsOf),
+			"nextCursor":               nextCursor,
+			"persistedTo":              persistedTo,
 		},
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
+// segmentParams reads the months, countryId, excludeCountryId, rule,
+// minRisk, monetary, window and limit query parameters shared by the
+// endpoints that classify users.
+func (h *Handler) segmentParams(c *fiber.Ctx, defaultLimit string) (controller.ClassifyOptions, int, error) {
+	var opts controller.ClassifyOptions
+
+	months, err := strconv.Atoi(c.Query("months", "1"))
+	if err != nil || months < 0 {
+		return opts, 0, errors.New("invalid months parameter")
+	}
+
+	countries, err := h.ctrl.ResolveCountries(c.Query("countryId"), c.Query("excludeCountryId"))
+	if err != nil {
+		return opts, 0, fmt.Errorf("invalid countryId or excludeCountryId parameter: %v", err)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", defaultLimit))
+	if err != nil || limit < 1 || limit > 1000 {
+		return opts, 0, errors.New("invalid limit parameter (max 1000)")
+	}
+
+	rule, err := h.ctrl.ResolveRule(c.Query("rule"), months, c.Query("mode"))
+	if err != nil {
+		return opts, 0, fmt.Errorf("invalid rule or mode parameter: %v", err)
+	}
+
+	var asOf time.Time
+	if raw := c.Query("asOf"); raw != "" {
+		asOf, err = parseTime(raw)
+		if err != nil {
+			return opts, 0, errors.New("invalid asOf parameter (unix seconds, YYYY-MM-DD or RFC 3339)")
+		}
+	}
+
+	clients, err := clientFilterParams(c)
+	if err != nil {
+		return opts, 0, err
+	}
+
+	var minRisk *float64
+	if raw := c.Query("minRisk"); raw != "" {
+		v, err := churn.ParseMinRisk(raw)
+		if err != nil {
+			return opts, 0, fmt.Errorf("invalid minRisk parameter: %v", err)
+		}
+		minRisk = &v
+	}
+
+	var window rules.Window
+	if raw := c.Query("window"); raw != "" {
+		window, err = rules.ParseWindow(raw)
+		if err != nil {
+			return opts, 0, fmt.Errorf("invalid window parameter: %v", err)
+		}
+	}
+
+	opts = controller.ClassifyOptions{Countries: countries, Months: months, Rule: rule, AsOf: asOf, Clients: clients, MinRisk: minRisk, Monetary: c.QueryBool("monetary"), Window: window}
+	return opts, limit, nil
+}
+
+// clientFilterParams reads the createdFrom, createdTo, platform, state,
+// balanceFrom and balanceTo query parameters that narrow the client scan.
+func clientFilterParams(c *fiber.Ctx) (models.ClientFilter, error) {
+	var filter models.ClientFilter
+
+	if raw := c.Query("createdFrom"); raw != "" {
+		from, err := parseTime(raw)
+		if err != nil {
+			return filter, errors.New("invalid createdFrom parameter (unix seconds, YYYY-MM-DD or RFC 3339)")
+		}
+		filter.CreatedFrom = from.Unix()
+	}
+	if raw := c.Query("createdTo"); raw != "" {
+		to, err := parseTimeEnd(raw)
+		if err != nil {
+			return filter, errors.New("invalid createdTo parameter (unix seconds, YYYY-MM-DD or RFC 3339)")
+		}
+		filter.CreatedTo = to.Unix()
+	}
+	if filter.CreatedFrom != 0 && filter.CreatedTo != 0 && filter.CreatedFrom > filter.CreatedTo {
+		return filter, errors.New("createdFrom must not be after createdTo")
+	}
+
+	var err error
+	if filter.Platforms, err = intListParam(c.Query("platform")); err != nil {
+		return filter, errors.New("invalid platform parameter (comma separated integers)")
+	}
+	if filter.States, err = intListParam(c.Query("state")); err != nil {
+		return filter, errors.New("invalid state parameter (comma separated integers)")
+	}
+
+	if raw := c.Query("balanceFrom"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return filter, errors.New("invalid balanceFrom parameter")
+		}
+		filter.BalanceFrom = &v
+	}
+	if raw := c.Query("balanceTo"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return filter, errors.New("invalid balanceTo parameter")
+		}
+		filter.BalanceTo = &v
+	}
+	if filter.BalanceFrom != nil && filter.BalanceTo != nil && *filter.BalanceFrom > *filter.BalanceTo {
+		return filter, errors.New("balanceFrom must not be greater than balanceTo")
+	}
+
+	return filter, nil
+}
+
+// intListParam parses a comma separated list of integers; empty is nil.
+func intListParam(raw string) ([]int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var out []int
+	for _, part := range strings.Split(raw, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// parseTime accepts unix seconds, a YYYY-MM-DD date (UTC midnight) or an
+// RFC 3339 timestamp.
+func parseTime(raw string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// parseTimeEnd is parseTime for the upper bound of a period: a bare
+// YYYY-MM-DD date covers that whole day.
+func parseTimeEnd(raw string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return parseTime(raw)
+}
+
+// asOfUnix reports the evaluation time of a run; 0 means the current time.
+func asOfUnix(asOf time.Time) int64 {
+	if asOf.IsZero() {
+		return 0
+	}
+	return asOf.Unix()
+}
+
+func (h *Handler) ListRules(c *fiber.Ctx) error {
+	months, err := strconv.Atoi(c.Query("months", "1"))
+	if err != nil || months < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid months parameter",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"rules": h.ctrl.Rules(months),
+		"modes": []rules.Mode{rules.ModeGap, rules.ModeSinceLast, rules.ModeBoth},
+	})
+}
+
 func (h *Handler) HealthCheck(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status":  "ok",
-		"message": "Server is running",
+		"status":     "ok",
+		"message":    "Server is running",
+		"opensearch": h.sem.Stats(),
 	})
 }
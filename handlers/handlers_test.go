@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/clock"
+	"action_users/controller"
+	"action_users/pool"
+	"action_users/reference"
+	"action_users/repositories"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMain(m *testing.M) {
+	time.Local = time.UTC
+	os.Exit(m.Run())
+}
+
+func newFixtureApp(t *testing.T) *fiber.App {
+	t.Helper()
+	store, err := repositories.LoadMemoryStore("../repositories/testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	h := NewHandler(controller.NewController(store, 1, nil, reference.Default(), clock.NewFake(now)), pool.NewSemaphore(1), nil)
+
+	app := fiber.New()
+	app.Get("/process-users", h.ProcessUsers)
+	return app
+}
+
+func TestProcessUsersFixtures(t *testing.T) {
+	app := newFixtureApp(t)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/process-users?months=3", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+
+	var body struct {
+		InactiveUsers []struct {
+			UserId string `json:"userId"`
+		} `json:"inactiveUsers"`
+		RegisteredNoActions []struct {
+			UserId string `json:"userId"`
+		} `json:"registeredNoActions"`
+		Summary struct {
+			InactiveUsersCount       int `json:"inactiveUsersCount"`
+			OrphanUsersCount         int `json:"orphanUsersCount"`
+			RegisteredNoActionsCount int `json:"registeredNoActionsCount"`
+			TotalProcessed           int `json:"totalProcessed"`
+		} `json:"summary"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	s := body.Summary
+	if s.TotalProcessed != 4 || s.InactiveUsersCount != 1 || s.OrphanUsersCount != 0 || s.RegisteredNoActionsCount != 1 {
+		t.Errorf("summary = %+v, want 4 processed, 1 inactive, 1 registered without actions", s)
+	}
+	if len(body.InactiveUsers) != 1 || body.InactiveUsers[0].UserId != "1004" {
+		t.Errorf("inactiveUsers = %+v, want 1004", body.InactiveUsers)
+	}
+	if len(body.RegisteredNoActions) != 1 || body.RegisteredNoActions[0].UserId != "1003" {
+		t.Errorf("registeredNoActions = %+v, want 1003", body.RegisteredNoActions)
+	}
+}
+
+func TestProcessUsersModes(t *testing.T) {
+	app := newFixtureApp(t)
+
+	tests := []struct {
+		mode                string
+		inactive, noActions []string
+	}{
+		{"gap", []string{"1004"}, []string{"1003"}},
+		{"since-last", []string{"1001", "1004"}, []string{"1003"}},
+		{"both", []string{"1001", "1004"}, []string{"1003"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/process-users?months=3&asOf=2026-10-01&mode="+tt.mode, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+			}
+
+			type user struct {
+				UserId string `json:"userId"`
+			}
+			var body struct {
+				InactiveUsers       []user `json:"inactiveUsers"`
+				RegisteredNoActions []user `json:"registeredNoActions"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := func(users []user) []string {
+				var out []string
+				for _, u := range users {
+					out = append(out, u.UserId)
+				}
+				slices.Sort(out)
+				return out
+			}
+			if got := ids(body.InactiveUsers); !slices.Equal(got, tt.inactive) {
+				t.Errorf("inactiveUsers = %v, want %v", got, tt.inactive)
+			}
+			if got := ids(body.RegisteredNoActions); !slices.Equal(got, tt.noActions) {
+				t.Errorf("registeredNoActions = %v, want %v", got, tt.noActions)
+			}
+		})
+	}
+}
+
+func TestProcessUsersInvalidParams(t *testing.T) {
+	app := newFixtureApp(t)
+
+	for _, query := range []string{"months=-1", "limit=0", "limit=1001", "page=0", "mode=weekly", "asOf=yesterday", "minRisk=2", "minRisk=extreme", "window=3y"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/process-users?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, resp.StatusCode, fiber.StatusBadRequest)
+		}
+	}
+}
+
+func TestProcessUsersCursorScope(t *testing.T) {
+	app := newFixtureApp(t)
+
+	get := func(query string) (int, string) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest("GET", "/process-users?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Summary struct {
+				NextCursor string `json:"nextCursor"`
+			} `json:"summary"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body.Summary.NextCursor
+	}
+
+	status, cursor := get("months=3&limit=2&pagination=cursor")
+	if status != fiber.StatusOK || cursor == "" {
+		t.Fatalf("first page: status = %d, nextCursor = %q", status, cursor)
+	}
+
+	if status, _ := get("months=3&limit=2&cursor=" + cursor); status != fiber.StatusOK {
+		t.Errorf("same parameters: status = %d, want %d", status, fiber.StatusOK)
+	}
+	for _, query := range []string{"months=6&limit=2", "months=3&limit=2&countryId=213", "months=3&limit=2&mode=both", "months=3&limit=2&asOf=2026-10-01"} {
+		if status, _ := get(query + "&cursor=" + cursor); status != fiber.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, status, fiber.StatusBadRequest)
+		}
+	}
+}
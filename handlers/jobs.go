@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"time"
+
+	"action_users/jobs"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// jobResultChunkRows is how many rows of a job result are written between
+// flushes, each flush getting exportChunkTimeout of its own.
+const jobResultChunkRows = 1000
+
+func jobView(snapshot jobs.Snapshot) fiber.Map {
+	return fiber.Map{
+		"job":       snapshot,
+		"statusUrl": "/jobs/" + snapshot.Id,
+		"resultUrl": "/jobs/" + snapshot.Id + "/result",
+	}
+}
+
+// CreateProcessUsersJob starts a background run of ProcessUsers over every
+// client of the country; limit is the page size of the walk.
+func (h *Handler) CreateProcessUsersJob(c *fiber.Ctx) error {
+	opts, limit, err := h.segmentParams(c, "1000")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	job, err := h.jobs.Start(jobs.Params{
+		Months:    opts.Months,
+		Countries: opts.Countries,
+		Rule:      opts.Rule,
+		AsOf:      opts.AsOf,
+		Clients:   opts.Clients,
+		MinRisk:   opts.MinRisk,
+		Monetary:  opts.Monetary,
+		Window:    opts.Window,
+		Limit:     limit,
+		Persist:   c.QueryBool("persist"),
+	})
+	if err != nil {
+		log.Printf("error: failed to start job: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to start job",
+		})
+	}
+
+	snapshot := job.Snapshot()
+	c.Set(fiber.HeaderLocation, "/jobs/"+snapshot.Id)
+	return c.Status(fiber.StatusAccepted).JSON(jobView(snapshot))
+}
+
+func (h *Handler) GetJob(c *fiber.Ctx) error {
+	job, ok := h.jobs.Get(c.Params("id"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "job not found",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(jobView(job.Snapshot()))
+}
+
+func (h *Handler) CancelJob(c *fiber.Ctx) error {
+	job, ok := h.jobs.Cancel(c.Params("id"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "job not found",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(jobView(job.Snapshot()))
+}
+
+// GetJobResult returns what the job classified, in the /process-users shape
+// by default or as an NDJSON/CSV download when format is given. Results of
+// running or cancelled jobs are partial.
+func (h *Handler) GetJobResult(c *fiber.Ctx) error {
+	job, ok := h.jobs.Get(c.Params("id"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "job not found",
+		})
+	}
+	snapshot := job.Snapshot()
+	result := job.Result()
+	if snapshot.ResultDropped {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "job result is no longer kept, start the job again",
+		})
+	}
+
+	if c.Query("format", "json") == "json" {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"orphanUsers":         result.Orphan,
+			"inactiveUsers":       result.Inactive,
+			"registeredNoActions": result.RegisteredNoActions,
+			"atRiskUsers":         result.AtRisk,
+			"summary": fiber.Map{
+				"orphanUsersCount":         len(result.Orphan),
+				"inactiveUsersCount":       len(result.Inactive),
+				"registeredNoActionsCount": len(result.RegisteredNoActions),
+				"atRiskUsersCount":         len(result.AtRisk),
+				"totalProcessed":           snapshot.Progress.UsersScanned,
+				"months":                   snapshot.Params.Months,
+				"countryId":                snapshot.Params.Countries.Single(),
+				"countries":                snapshot.Params.Countries,
+				"status":                   snapshot.Status,
+			},
+		})
+	}
+
+	segment := c.Query("segment", "all")
+	if !validSegment(segment) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid segment parameter (inactive, orphan, registeredNoActions, atRisk, all)",
+		})
+	}
+	format, ok := exportFormat(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid format parameter (json, ndjson, csv)",
+		})
+	}
+	filename := fmt.Sprintf("job-%s-%s-users.%s", snapshot.Id, segment, format)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	rows := segmentRows(result, segment)
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		rw, err := newRowWriter(format, w)
+		if err != nil {
+			return
+		}
+		flush := func() error {
+			if err := conn.SetWriteDeadline(time.Now().Add(exportChunkTimeout)); err != nil {
+				return err
+			}
+			return rw.Flush()
+		}
+
+		for i, cd := range rows {
+			err = rw.Write(cd)
+			if err == nil && (i+1)%jobResultChunkRows == 0 {
+				err = flush()
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			log.Printf("warn: job %s result download aborted: %v", snapshot.Id, err)
+			rw.Abort()
+		}
+	})
+	return nil
+}
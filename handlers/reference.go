@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"action_users/reference"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type countryView struct {
+	reference.Country
+	CurrencyCode string `json:"currencyCode,omitempty"`
+}
+
+type currencyView struct {
+	reference.Currency
+	Countries []int `json:"countries"`
+}
+
+// ListCountries returns the country reference table with each country's
+// reporting currency and the configured country groups.
+func (h *Handler) ListCountries(c *fiber.Ctx) error {
+	ref := h.ctrl.Reference()
+	countries := ref.Countries()
+	out := make([]countryView, 0, len(countries))
+	for _, country := range countries {
+		out = append(out, countryView{Country: country, CurrencyCode: ref.CurrencyCode(country.CurrencyId)})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"countries": out,
+		"groups":    ref.Groups(),
+	})
+}
+
+// ListCurrencies returns the currencies with their rates and the countries
+// reported in each, and the reporting currency monetary totals use.
+func (h *Handler) ListCurrencies(c *fiber.Ctx) error {
+	ref := h.ctrl.Reference()
+	byCurrency := map[int][]int{}
+	for _, country := range ref.Countries() {
+		if country.CurrencyId != 0 {
+			byCurrency[country.CurrencyId] = append(byCurrency[country.CurrencyId], country.Id)
+		}
+	}
+
+	currencies := ref.Currencies()
+	out := make([]currencyView, 0, len(currencies))
+	for _, cur := range currencies {
+		countries := byCurrency[cur.Id]
+		if countries == nil {
+			countries = []int{}
+		}
+		out = append(out, currencyView{Currency: cur, Countries: countries})
+	}
+	response := fiber.Map{
+		"currencies": out,
+	}
+	if reporting, ok := ref.ReportingCurrency(); ok {
+		response["reportingCurrency"] = reporting.Code
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
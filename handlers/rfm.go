@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"action_users/controller"
+	"action_users/rfm"
+	"action_users/rules"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RFMSegments scores every client matching the countryId, asOf and client
+// filter parameters by recency, frequency and monetary value over window
+// and returns the segment sizes with one page of users, optionally of a
+// single segment. Pages of the same segmentation are cut from the result
+// the controller keeps in memory.
+func (h *Handler) RFMSegments(c *fiber.Ctx) error {
+	opts, err := h.scopeParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	clients, err := clientFilterParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	window, err := rules.ParseWindow(c.Query("window", "12m"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid window parameter: " + err.Error(),
+		})
+	}
+
+	segment := c.Query("segment")
+	if segment != "" && !slices.Contains(rfm.Segments, segment) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":    "invalid segment parameter",
+			"segments": rfm.Segments,
+		})
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid page parameter",
+		})
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid limit parameter (max 1000)",
+		})
+	}
+
+	start := time.Now()
+	result, err := h.ctrl.RFMSegments(controller.RFMOptions{
+		Countries: opts.Countries,
+		Window:    window,
+		AsOf:      opts.AsOf,
+		Clients:   clients,
+	})
+	if err != nil {
+		log.Printf("error: failed to compute RFM segments: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to compute RFM segments",
+		})
+	}
+	log.Printf("info: RFM segments of %d users (window %s) in %v", len(result.Users), result.Window, time.Since(start))
+
+	// result is shared with the requests for the other pages.
+	users := result.Users
+	if segment != "" {
+		users = []controller.RFMUser{}
+		for _, u := range result.Users {
+			if u.Segment == segment {
+				users = append(users, u)
+			}
+		}
+	}
+	total := len(users)
+	from := min((page-1)*limit, total)
+	users = users[from:min(from+limit, total)]
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"summary": result,
+		"users":   users,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	})
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"action_users/controller"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statsParams reads the months and mode query parameters of the
+// statistics endpoints besides those of scopeParams.
+func (h *Handler) statsParams(c *fiber.Ctx, defaultMonths string) (controller.StatsOptions, error) {
+	opts, err := h.scopeParams(c)
+	if err != nil {
+		return opts, err
+	}
+
+	months, err := strconv.Atoi(c.Query("months", defaultMonths))
+	if err != nil || months < 1 {
+		return opts, errors.New("invalid months parameter")
+	}
+
+	rule, err := h.ctrl.ResolveRule("", months, c.Query("mode"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid mode parameter: %v", err)
+	}
+
+	opts.Months, opts.Rule = months, rule
+	return opts, nil
+}
+
+// scopeParams reads the countryId, excludeCountryId and asOf query
+// parameters, all that the endpoints without a months window take.
+func (h *Handler) scopeParams(c *fiber.Ctx) (controller.StatsOptions, error) {
+	var opts controller.StatsOptions
+
+	countries, err := h.ctrl.ResolveCountries(c.Query("countryId"), c.Query("excludeCountryId"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid countryId or excludeCountryId parameter: %v", err)
+	}
+
+	var asOf time.Time
+	if raw := c.Query("asOf"); raw != "" {
+		asOf, err = parseTime(raw)
+		if err != nil {
+			return opts, errors.New("invalid asOf parameter (unix seconds, YYYY-MM-DD or RFC 3339)")
+		}
+	}
+
+	return controller.StatsOptions{Countries: countries, AsOf: asOf}, nil
+}
+
+// InactivityStats returns the inactive, orphan and registered-without-actions
+// totals broken down by country, platform, registration month and last
+// action type.
+func (h *Handler) InactivityStats(c *fiber.Ctx) error {
+	opts, err := h.statsParams(c, "1")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	start := time.Now()
+	stats, err := h.ctrl.InactivityStats(opts)
+	if err != nil {
+		log.Printf("error: failed to compute inactivity stats: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to compute inactivity stats",
+		})
+	}
+	log.Printf("info: inactivity stats over %d users with actions in %v", stats.UsersWithActions, time.Since(start))
+
+	return c.Status(fiber.StatusOK).JSON(stats)
+}
+
+// maxRetentionMonths bounds the width of the retention matrix.
+const maxRetentionMonths = 120
+
+// RetentionMatrix returns the cohort retention matrix as JSON or, with
+// format=csv, as a CSV table with one row per cohort.
+func (h *Handler) RetentionMatrix(c *fiber.Ctx) error {
+	opts, err := h.statsParams(c, "12")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if opts.Months > maxRetentionMonths {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("invalid months parameter (max %d)", maxRetentionMonths),
+		})
+	}
+
+	format := c.Query("format", "json")
+	if format != "json" && format != formatCSV {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid format parameter (json, csv)",
+		})
+	}
+
+	start := time.Now()
+	retention, err := h.ctrl.RetentionMatrix(opts)
+	if err != nil {
+		log.Printf("error: failed to compute retention matrix: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to compute retention matrix",
+		})
+	}
+	log.Printf("info: retention matrix of %d cohorts in %v", len(retention.Cohorts), time.Since(start))
+
+	if format == "json" {
+		return c.Status(fiber.StatusOK).JSON(retention)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("retention-%s.csv", h.ctrl.Now().Format("2006-01-02"))))
+
+	w := csv.NewWriter(c)
+	header := []string{"cohort", "size"}
+	for k := 0; k <= retention.Months; k++ {
+		header = append(header, fmt.Sprintf("month%d", k))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, cohort := range retention.Cohorts {
+		row := make([]string, len(header))
+		row[0] = cohort.Cohort
+		row[1] = strconv.Itoa(cohort.Size)
+		for k, share := range cohort.Retention {
+			row[2+k] = strconv.FormatFloat(share, 'f', -1, 64)
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Funnel returns the registration-to-first-withdrawal funnel of the clients
+// selected by the countryId, asOf and client filter parameters.
+func (h *Handler) Funnel(c *fiber.Ctx) error {
+	opts, err := h.scopeParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	clients, err := clientFilterParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	start := time.Now()
+	funnel, err := h.ctrl.FunnelAnalysis(controller.FunnelOptions{Countries: opts.Countries, AsOf: opts.AsOf, Clients: clients})
+	if err != nil {
+		log.Printf("error: failed to compute funnel: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to compute funnel",
+		})
+	}
+	log.Printf("info: funnel over %d clients in %v", funnel.Steps[0].Users, time.Since(start))
+
+	return c.Status(fiber.StatusOK).JSON(funnel)
+}
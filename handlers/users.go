@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"action_users/constants"
+	"action_users/controller"
+	"action_users/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UserTimeline lists the actions of one user across all action indices,
+// newest first.
+func (h *Handler) UserTimeline(c *fiber.Ctx) error {
+	userId := c.Params("userId")
+	if _, err := strconv.ParseInt(userId, 10, 64); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid userId",
+		})
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid page parameter",
+		})
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid limit parameter (max 1000)",
+		})
+	}
+
+	if page*limit > controller.MaxTimelineWindow {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "page * limit must not exceed 10000, narrow the date range instead",
+		})
+	}
+
+	types, err := actionTypesParam(c.Query("type"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var r models.ActionRange
+	if raw := c.Query("from"); raw != "" {
+		from, err := parseTime(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid from parameter (unix seconds, YYYY-MM-DD or RFC 3339)",
+			})
+		}
+		r.From = from.Unix()
+	}
+	if raw := c.Query("to"); raw != "" {
+		to, err := parseTimeEnd(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid to parameter (unix seconds, YYYY-MM-DD or RFC 3339)",
+			})
+		}
+		r.To = to.Unix()
+	}
+	if r.From != 0 && r.To != 0 && r.From > r.To {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "from must not be after to",
+		})
+	}
+
+	actions, hasMore, err := h.ctrl.GetUserTimeline(userId, controller.TimelineQuery{
+		Types:  types,
+		Range:  r,
+		Offset: (page - 1) * limit,
+		Limit:  limit,
+	})
+	if err != nil {
+		log.Printf("error: getUserTimeline %s failed: %v", userId, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch user actions",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"userId":  userId,
+		"actions": actions,
+		"page":    page,
+		"limit":   limit,
+		"hasMore": hasMore,
+		"filters": fiber.Map{
+			"type": types,
+			"from": r.From,
+			"to":   r.To,
+		},
+	})
+}
+
+// UserStatus classifies a single user the way ProcessUsers would and
+// explains the outcome. It takes the same months, countryId, rule, mode and
+// asOf parameters.
+func (h *Handler) UserStatus(c *fiber.Ctx) error {
+	userId := c.Params("userId")
+	if _, err := strconv.ParseInt(userId, 10, 64); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid userId",
+		})
+	}
+
+	opts, _, err := h.segmentParams(c, "1")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	status, found, err := h.ctrl.GetUserStatus(userId, opts)
+	if err != nil {
+		log.Printf("error: getUserStatus %s failed: %v", userId, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to classify user",
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "user has neither a client document nor actions",
+			"trace": status.Trace,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(status)
+}
+
+// actionTypesParam parses a comma separated list of action types such as
+// "TOP_UP,BET"; an empty value selects all of them.
+func actionTypesParam(raw string) ([]models.ActionType, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	known := map[models.ActionType]bool{}
+	for _, t := range constants.ActionTypes {
+		known[t] = true
+	}
+
+	var types []models.ActionType
+	for _, part := range strings.Split(raw, ",") {
+		t := models.ActionType(strings.ToUpper(strings.TrimSpace(part)))
+		if !known[t] {
+			return nil, fmt.Errorf("invalid type parameter %q (TOP_UP, BET, WITHDRAWAL, CASHIER_CARD, TERMINAL_TRANSACTION)", part)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
@@ -0,0 +1,276 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"action_users/controller"
+	"action_users/models"
+	"action_users/rules"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+const (
+	// retention is how long finished jobs are kept around.
+	retention = 24 * time.Hour
+	// maxResults is how many finished jobs keep their result; older ones
+	// keep only their snapshot, with ResultDropped set.
+	maxResults = 5
+)
+
+// Params are the /process-users parameters a job runs with; Limit is the
+// page size of the internal walk over all clients and Persist writes every
+// page to the segments index as it is classified.
+type Params struct {
+	Months    int                 `json:"months"`
+	Countries models.Countries    `json:"countries"`
+	Rule      rules.Rule          `json:"rule"`
+	AsOf      time.Time           `json:"asOf,omitzero"`
+	Clients   models.ClientFilter `json:"clients,omitzero"`
+	MinRisk   *float64            `json:"minRisk,omitempty"`
+	Monetary  bool                `json:"monetary,omitempty"`
+	Window    rules.Window        `json:"window,omitzero"`
+	Limit     int                 `json:"limit"`
+	Persist   bool                `json:"persist"`
+}
+
+type Progress struct {
+	UsersScanned             int `json:"usersScanned"`
+	InactiveUsersCount       int `json:"inactiveUsersCount"`
+	OrphanUsersCount         int `json:"orphanUsersCount"`
+	RegisteredNoActionsCount int `json:"registeredNoActionsCount"`
+	AtRiskUsersCount         int `json:"atRiskUsersCount"`
+}
+
+// Snapshot is the JSON view of a job at one moment.
+type Snapshot struct {
+	Id        string   `json:"id"`
+	Status    Status   `json:"status"`
+	Params    Params   `json:"params"`
+	Progress  Progress `json:"progress"`
+	PersistTo string   `json:"persistTo,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	// ResultDropped is set once the result was let go to make room for
+	// those of newer jobs.
+	ResultDropped bool       `json:"resultDropped,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+}
+
+type Job struct {
+	mu       sync.Mutex
+	snapshot Snapshot
+	result   models.Segments
+	cancel   context.CancelFunc
+}
+
+func (j *Job) Snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshot
+}
+
+// Result returns what the job has classified so far; for a cancelled or
+// failed job that is the partial result up to the last finished page.
+func (j *Job) Result() models.Segments {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.result
+}
+
+func (j *Job) finished() bool {
+	switch j.snapshot.Status {
+	case StatusCompleted, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (j *Job) finish(status Status, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.snapshot.Status = status
+	j.snapshot.FinishedAt = &now
+	if err != nil {
+		j.snapshot.Error = err.Error()
+	}
+}
+
+// Manager runs segmentation jobs in the background and keeps them for the
+// retention period after they finish, results included for the newest
+// maxResults of them. Expired jobs are pruned whenever a job is started or
+// looked up.
+type Manager struct {
+	ctrl *controller.Controller
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager(ctrl *controller.Controller) *Manager {
+	return &Manager{ctrl: ctrl, jobs: map[string]*Job{}}
+}
+
+func (m *Manager) Start(params Params) (*Job, error) {
+	id, err := newId()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		snapshot: Snapshot{
+			Id:        id,
+			Status:    StatusQueued,
+			Params:    params,
+			CreatedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	m.prune()
+	m.jobs[id] = job
+	m.mu.Unlock()
+
+	go m.run(ctx, job)
+	return job, nil
+}
+
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Cancel stops a queued or running job after the page it is working on. The
+// job stays available with whatever it had classified by then.
+func (m *Manager) Cancel(id string) (*Job, bool) {
+	job, ok := m.Get(id)
+	if !ok {
+		return nil, false
+	}
+	job.cancel()
+	return job, true
+}
+
+// prune drops the jobs that finished more than retention ago and the
+// results of all but the newest maxResults finished jobs. m.mu must be held.
+func (m *Manager) prune() {
+	cutoff := time.Now().Add(-retention)
+	var kept []*Job
+	for id, job := range m.jobs {
+		job.mu.Lock()
+		finished := job.finished()
+		expired := finished && job.snapshot.FinishedAt.Before(cutoff)
+		job.mu.Unlock()
+		switch {
+		case expired:
+			delete(m.jobs, id)
+		case finished:
+			kept = append(kept, job)
+		}
+	}
+	if len(kept) <= maxResults {
+		return
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].snapshot.FinishedAt.After(*kept[j].snapshot.FinishedAt)
+	})
+	for _, job := range kept[maxResults:] {
+		job.mu.Lock()
+		job.result = models.Segments{}
+		job.snapshot.ResultDropped = true
+		job.mu.Unlock()
+	}
+}
+
+func (m *Manager) run(ctx context.Context, job *Job) {
+	defer job.cancel()
+
+	job.mu.Lock()
+	now := time.Now()
+	job.snapshot.Status = StatusRunning
+	job.snapshot.StartedAt = &now
+	params := job.snapshot.Params
+	opts := controller.ClassifyOptions{
+		Countries: params.Countries,
+		Months:    params.Months,
+		Rule:      params.Rule,
+		AsOf:      params.AsOf,
+		Clients:   params.Clients,
+		MinRisk:   params.MinRisk,
+		Monetary:  params.Monetary,
+		Window:    params.Window,
+	}
+	if params.Persist {
+		job.snapshot.PersistTo = m.ctrl.SegmentsIndex(opts)
+	}
+	persistTo := job.snapshot.PersistTo
+	job.mu.Unlock()
+
+	log.Printf("info: job %s started (countries %+v, months %d)", job.snapshot.Id, params.Countries, params.Months)
+
+	err := ctx.Err()
+	if err == nil {
+		err = m.ctrl.WalkSegments(opts, params.Limit, func(userIds []string, segments models.Segments) error {
+			if persistTo != "" {
+				if err := m.ctrl.SaveSegments(persistTo, segments, opts); err != nil {
+					return err
+				}
+			}
+			job.mu.Lock()
+			job.result.Inactive = append(job.result.Inactive, segments.Inactive...)
+			job.result.Orphan = append(job.result.Orphan, segments.Orphan...)
+			job.result.RegisteredNoActions = append(job.result.RegisteredNoActions, segments.RegisteredNoActions...)
+			job.result.AtRisk = append(job.result.AtRisk, segments.AtRisk...)
+			job.snapshot.Progress.UsersScanned += len(userIds)
+			job.snapshot.Progress.InactiveUsersCount = len(job.result.Inactive)
+			job.snapshot.Progress.OrphanUsersCount = len(job.result.Orphan)
+			job.snapshot.Progress.RegisteredNoActionsCount = len(job.result.RegisteredNoActions)
+			job.snapshot.Progress.AtRiskUsersCount = len(job.result.AtRisk)
+			job.mu.Unlock()
+			return ctx.Err()
+		})
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Printf("info: job %s cancelled", job.snapshot.Id)
+		job.finish(StatusCancelled, nil)
+	case err != nil:
+		log.Printf("error: job %s failed: %v", job.snapshot.Id, err)
+		job.finish(StatusFailed, err)
+	default:
+		log.Printf("info: job %s completed", job.snapshot.Id)
+		job.finish(StatusCompleted, nil)
+	}
+}
+
+func newId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"action_users/models"
+)
+
+func finishedJob(id string, finishedAt time.Time) *Job {
+	return &Job{
+		snapshot: Snapshot{Id: id, Status: StatusCompleted, FinishedAt: &finishedAt},
+		result:   models.Segments{Inactive: []models.ClientData{{UserId: id}}},
+	}
+}
+
+func TestPrune(t *testing.T) {
+	m := NewManager(nil)
+	now := time.Now()
+	m.jobs["expired"] = finishedJob("expired", now.Add(-retention-time.Minute))
+	for i := range maxResults + 2 {
+		id := fmt.Sprintf("job-%d", i)
+		m.jobs[id] = finishedJob(id, now.Add(-time.Duration(i)*time.Hour))
+	}
+	m.jobs["running"] = &Job{snapshot: Snapshot{Id: "running", Status: StatusRunning}}
+
+	if _, ok := m.Get("expired"); ok {
+		t.Error("job finished before the retention period is still kept")
+	}
+	if _, ok := m.Get("running"); !ok {
+		t.Error("running job was pruned")
+	}
+	for i := range maxResults + 2 {
+		job, ok := m.Get(fmt.Sprintf("job-%d", i))
+		if !ok {
+			t.Fatalf("job-%d was pruned", i)
+		}
+		dropped := i >= maxResults
+		if got := job.Snapshot().ResultDropped; got != dropped {
+			t.Errorf("job-%d: ResultDropped = %v, want %v", i, got, dropped)
+		}
+		if got := len(job.Result().Inactive) == 0; got != dropped {
+			t.Errorf("job-%d: result emptied = %v, want %v", i, got, dropped)
+		}
+	}
+}
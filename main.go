@@ -1,9 +1,13 @@
 package main
 
 import (
+	"action_users/clock"
 	"action_users/config"
 	"action_users/controller"
 	"action_users/handlers"
+	"action_users/jobs"
+	"action_users/pool"
+	"action_users/repositories"
 	"action_users/routes"
 	"log"
 	"os"
@@ -12,21 +16,54 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/opensearch-project/opensearch-go"
 )
 
 func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
-	log.Println("info: initializing OpenSearch client...")
-	client, err := config.NewOpenSearchClient()
+	concurrency, err := config.LoadConcurrencyConfig()
 	if err != nil {
-		log.Fatalf("fatal: failed to create OpenSearch client: %v", err)
+		log.Fatalf("fatal: failed to load concurrency config: %v", err)
 	}
+	sem := pool.NewSemaphore(concurrency.MaxOpenSearchRequests)
 
-	ctrl := controller.NewController(client)
+	storeConfig, err := config.LoadStoreConfig()
+	if err != nil {
+		log.Fatalf("fatal: failed to load store config: %v", err)
+	}
+
+	var client *opensearch.Client
+	var store repositories.Store
+	if storeConfig.Backend == config.MemoryBackend {
+		log.Printf("info: loading in-memory store from %s...", storeConfig.FixturesPath)
+		store, err = repositories.LoadMemoryStore(storeConfig.FixturesPath)
+		if err != nil {
+			log.Fatalf("fatal: failed to load in-memory store: %v", err)
+		}
+	} else {
+		log.Println("info: initializing OpenSearch client...")
+		client, err = config.NewOpenSearchClient(sem)
+		if err != nil {
+			log.Fatalf("fatal: failed to create OpenSearch client: %v", err)
+		}
+		store = repositories.NewOpenSearchStore(client)
+	}
+
+	ruleSet, err := config.LoadRules()
+	if err != nil {
+		log.Fatalf("fatal: failed to load inactivity rules: %v", err)
+	}
+
+	ref, err := config.LoadReference()
+	if err != nil {
+		log.Fatalf("fatal: failed to load reference table: %v", err)
+	}
+
+	ctrl := controller.NewController(store, concurrency.Workers, ruleSet, ref, clock.Real{})
 
-	handler := handlers.NewHandler(ctrl)
+	handler := handlers.NewHandler(ctrl, sem, jobs.NewManager(ctrl))
 
 	app := fiber.New(fiber.Config{
 		AppName:               "User Actions API",
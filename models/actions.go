@@ -0,0 +1,34 @@
+package models
+
+// ActionType discriminates the documents of the action indices. Each index
+// in constants.Indices maps to exactly one type through constants.ActionTypes.
+type ActionType string
+
+const (
+	ActionTopUp               ActionType = "TOP_UP"
+	ActionBet                 ActionType = "BET"
+	ActionWithdrawal          ActionType = "WITHDRAWAL"
+	ActionCashierCard         ActionType = "CASHIER_CARD"
+	ActionTerminalTransaction ActionType = "TERMINAL_TRANSACTION"
+)
+
+// Action is a top-up, bet, withdrawal, cashier card or terminal transaction
+// document decoded from the index it was read from.
+type Action struct {
+	Type       ActionType `json:"type"`
+	Index      string     `json:"index"`
+	Id         string     `json:"id,omitempty"`
+	UserId     string     `json:"userId"`
+	CountryId  int        `json:"countryId"`
+	Amount     float64    `json:"amount"`
+	CurrencyId int        `json:"currencyId"`
+	Status     string     `json:"status"`
+	CreatedAt  int64      `json:"createdAt"`
+}
+
+// ActionRange limits actions to those created in [From, To]; a zero bound
+// is open.
+type ActionRange struct {
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "slices"
+
+// Countries selects clients and actions by user.countryId. An empty Include
+// means every country; Exclude removes countries from that selection.
+type Countries struct {
+	Include []int `json:"include,omitempty"`
+	Exclude []int `json:"exclude,omitempty"`
+}
+
+// SingleCountry is the selection of one country, or of all of them for 0.
+func SingleCountry(countryId int) Countries {
+	if countryId == 0 {
+		return Countries{}
+	}
+	return Countries{Include: []int{countryId}}
+}
+
+// IsAll reports whether the selection filters nothing.
+func (c Countries) IsAll() bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0
+}
+
+func (c Countries) Contains(countryId int) bool {
+	if len(c.Include) > 0 && !slices.Contains(c.Include, countryId) {
+		return false
+	}
+	return !slices.Contains(c.Exclude, countryId)
+}
+
+// Single is the one country selected, or 0 when the selection spans several
+// or all of them.
+func (c Countries) Single() int {
+	if len(c.Include) == 1 && !slices.Contains(c.Exclude, c.Include[0]) {
+		return c.Include[0]
+	}
+	return 0
+}
@@ -1,24 +1,133 @@
 package models
 
-import _ "time"
+import (
+	"encoding/json"
+	_ "time"
+)
 
 type Hit struct {
 	Index  string                 `json:"_index"`
+	Id     string                 `json:"_id"`
 	Source map[string]interface{} `json:"_source"`
+	Sort   []interface{}          `json:"sort,omitempty"`
 }
 
 type SearchResponse struct {
-	Hits struct {
+	PitId string `json:"pit_id,omitempty"`
+	Hits  struct {
 		Hits []Hit `json:"hits"`
 	} `json:"hits"`
+	Aggregations json.RawMessage `json:"aggregations,omitempty"`
 }
 
-type Account struct {
-	ActiveWallet string  `json:"activeWallet"`
+type MsearchItem struct {
+	Status       int         `json:"status"`
+	Error        interface{} `json:"error,omitempty"`
+	Aggregations struct {
+		Users struct {
+			Buckets []struct {
+				Key    interface{} `json:"key"`
+				Latest struct {
+					Hits struct {
+						Hits []Hit `json:"hits"`
+					} `json:"hits"`
+				} `json:"latest"`
+			} `json:"buckets"`
+		} `json:"users"`
+	} `json:"aggregations"`
+}
+
+type MsearchResponse struct {
+	Responses []MsearchItem `json:"responses"`
+}
+
+// ClientFilter narrows which clients-searcher documents are scanned.
+// Countries selects by user.countryId. CreatedBefore, in unix seconds,
+// drops clients registered after it but keeps those without createdAt;
+// CreatedFrom and CreatedTo select a registration period and drop them.
+// Platforms and States select by stats.platform and user.state. The
+// balance bounds match clients with at least one wallet in range. Zero
+// values and empty lists mean no limit.
+type ClientFilter struct {
+	Countries     Countries `json:"countries,omitzero"`
+	CreatedBefore int64     `json:"createdBefore,omitempty"`
+	CreatedFrom   int64     `json:"createdFrom,omitempty"`
+	CreatedTo     int64     `json:"createdTo,omitempty"`
+	Platforms     []int     `json:"platforms,omitempty"`
+	States        []int     `json:"states,omitempty"`
+	BalanceFrom   *float64  `json:"balanceFrom,omitempty"`
+	BalanceTo     *float64  `json:"balanceTo,omitempty"`
+}
+
+type Cursor struct {
+	PitId       string        `json:"pit"`
+	SearchAfter []interface{} `json:"after,omitempty"`
+	Scope       *CursorScope  `json:"scope,omitempty"`
+}
+
+// CursorScope is the query a paging cursor was issued for. It travels in
+// the token so that a cursor cannot be replayed against another filter,
+// months value or rule than the page it continues.
+type CursorScope struct {
+	Filter ClientFilter `json:"filter"`
+	Months int          `json:"months"`
+	Rule   string       `json:"rule,omitempty"`
+	Mode   string       `json:"mode,omitempty"`
+	AsOf   int64        `json:"asOf,omitempty"`
+}
+
+// Wallet is one wallet of a client; at most one per client is Active.
+type Wallet struct {
+	Number       string  `json:"number"`
 	Balance      float64 `json:"balance"`
 	CurrencyId   int     `json:"currencyId"`
+	CurrencyCode string  `json:"currencyCode,omitempty"`
+	Active       bool    `json:"active"`
 }
 
+// Account holds every wallet of a client. CurrencyId is the currency of the
+// client's country, or of the active wallet when the country has none.
+// TotalBalance sums all wallets in TotalCurrency, the reporting currency,
+// leaving out wallets in Unconverted currencies.
+type Account struct {
+	Wallets       []Wallet `json:"wallets"`
+	CurrencyId    int      `json:"currencyId"`
+	TotalBalance  float64  `json:"totalBalance"`
+	TotalCurrency string   `json:"totalCurrency,omitempty"`
+	Unconverted   []int    `json:"unconverted,omitempty"`
+}
+
+// ActiveWallet returns the wallet flagged active, if any.
+func (a Account) ActiveWallet() (Wallet, bool) {
+	for _, w := range a.Wallets {
+		if w.Active {
+			return w, true
+		}
+	}
+	return Wallet{}, false
+}
+
+// UserActions is what the action indices know about one user: the two most
+// recent actions overall plus the latest one of each action group.
+type UserActions struct {
+	Recent     []Action
+	TopUp      *Action
+	Bet        *Action
+	Withdrawal *Action
+}
+
+const (
+	SegmentInactive            = "inactive"
+	SegmentOrphan              = "orphan"
+	SegmentRegisteredNoActions = "registeredNoActions"
+	// SegmentActive only appears on single-user lookups; active users are
+	// left out of segmentation runs.
+	SegmentActive = "active"
+	// SegmentAtRisk holds the active users of a run with a minimum churn
+	// risk whose score reaches it.
+	SegmentAtRisk = "atRisk"
+)
+
 type ClientData struct {
 	Platform              int    `json:"platform"`
 	CreatedAt             int64  `json:"createdAt"`
@@ -27,13 +136,39 @@ type ClientData struct {
 	LastName              string `json:"lastName"`
 	Phone                 string `json:"phone"`
 	Account               Account
-	CountryId             int    `json:"countryId"`
-	State                 int    `json:"state"`
-	LastTopUp             int64  `json:"lastTopUp"`
-	LastBet               int64  `json:"lastBet"`
-	LastWithdrawal        int64  `json:"lastWithdrawal"`
-	UserId                string `json:"userId"`
-	LastActivity          int64  `json:"lastActivity"`
-	ReactivationThreshold int64  `json:"reactivationThreshold"`
-	CanReactivate         bool   `json:"canReactivate"`
+	CurrencyCode          string    `json:"currencyCode"`
+	CountryId             int       `json:"countryId"`
+	CountryName           string    `json:"countryName"`
+	State                 int       `json:"state"`
+	LastTopUp             int64     `json:"lastTopUp"`
+	LastBet               int64     `json:"lastBet"`
+	LastWithdrawal        int64     `json:"lastWithdrawal"`
+	UserId                string    `json:"userId"`
+	LastActivity          int64     `json:"lastActivity"`
+	ReactivationThreshold int64     `json:"reactivationThreshold"`
+	CanReactivate         bool      `json:"canReactivate"`
+	ChurnScore            float64   `json:"churnScore,omitempty"`
+	RiskBand              string    `json:"riskBand,omitempty"`
+	Monetary              *Monetary `json:"monetary,omitempty"`
+	Actions               []Action  `json:"actions"`
+	Segment               string    `json:"segment,omitempty"`
+	InactivityRule        string    `json:"inactivityRule,omitempty"`
+	InactivityReasons     []string  `json:"inactivityReasons,omitempty"`
+}
+
+// SegmentDocument is a classified user as written back to the
+// user-activity-segments indices.
+type SegmentDocument struct {
+	ClientData
+	Months     int   `json:"months"`
+	AsOf       int64 `json:"asOf,omitempty"`
+	ComputedAt int64 `json:"computedAt"`
+}
+
+// Segments are the buckets ProcessUsers sorts a page of users into.
+type Segments struct {
+	Inactive            []ClientData
+	Orphan              []ClientData
+	RegisteredNoActions []ClientData
+	AtRisk              []ClientData
 }
@@ -0,0 +1,100 @@
+package models
+
+// ClientBreakdown counts clients-searcher documents by country, platform and
+// registration month ("2006-01", UTC). Clients without a value count under
+// country or platform 0 and the empty month.
+type ClientBreakdown struct {
+	Total      int            `json:"total"`
+	ByCountry  map[int]int    `json:"byCountry"`
+	ByPlatform map[int]int    `json:"byPlatform"`
+	ByCohort   map[string]int `json:"byCohort"`
+}
+
+// ClientJoin is what clients-searcher holds on one group of users:
+// how many of them have a document at all, and the breakdown of those
+// whose document matches the filter of the lookup.
+type ClientJoin struct {
+	Registered int
+	Matching   ClientBreakdown
+}
+
+// LastAction is the latest action of a user across the action indices.
+// PreviousAt is the createdAt of the action before it, 0 when there is
+// only one.
+type LastAction struct {
+	UserId     string
+	Type       ActionType
+	CountryId  int
+	CreatedAt  int64
+	PreviousAt int64
+}
+
+// ActionStats summarises the actions of one user in one index over a range:
+// how many there are, their amounts summed and counted per currencyId and
+// the first and last createdAt.
+type ActionStats struct {
+	Count   int             `json:"count"`
+	Amounts map[int]float64 `json:"amounts,omitempty"`
+	Counts  map[int]int     `json:"counts,omitempty"`
+	First   int64           `json:"first,omitempty"`
+	Last    int64           `json:"last,omitempty"`
+}
+
+// Add merges o into s.
+func (s *ActionStats) Add(o ActionStats) {
+	if o.Count == 0 {
+		return
+	}
+	if s.Count == 0 || o.First < s.First {
+		s.First = o.First
+	}
+	if o.Last > s.Last {
+		s.Last = o.Last
+	}
+	s.Count += o.Count
+	for currencyId, amount := range o.Amounts {
+		if s.Amounts == nil {
+			s.Amounts = map[int]float64{}
+		}
+		s.Amounts[currencyId] += amount
+	}
+	for currencyId, n := range o.Counts {
+		if s.Counts == nil {
+			s.Counts = map[int]int{}
+		}
+		s.Counts[currencyId] += n
+	}
+}
+
+// Amount is the sum of Amounts regardless of currency.
+func (s ActionStats) Amount() float64 {
+	var total float64
+	for _, amount := range s.Amounts {
+		total += amount
+	}
+	return total
+}
+
+// MoneyTotals are the sums of a user's actions over one period in the
+// reporting currency. NetRevenue is Deposited minus Withdrawn. Bets counts
+// every bet, but AverageBet is Wagered over the bets in currencies that
+// could be converted, as only those are in Wagered.
+type MoneyTotals struct {
+	Deposited  float64 `json:"deposited"`
+	Wagered    float64 `json:"wagered"`
+	Withdrawn  float64 `json:"withdrawn"`
+	NetRevenue float64 `json:"netRevenue"`
+	Bets       int     `json:"bets"`
+	AverageBet float64 `json:"averageBet"`
+}
+
+// Monetary are the lifetime totals of a user and those of the window
+// starting at WindowFrom. Amounts in Unconverted currencies have no rate to
+// the reporting currency and are left out of both.
+type Monetary struct {
+	Currency    string      `json:"currency"`
+	Lifetime    MoneyTotals `json:"lifetime"`
+	Window      MoneyTotals `json:"window"`
+	WindowFrom  int64       `json:"windowFrom"`
+	Unconverted []int       `json:"unconverted,omitempty"`
+}
@@ -0,0 +1,126 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Semaphore caps how many operations run at once and keeps track of how many
+// callers are queued behind the cap.
+type Semaphore struct {
+	slots   chan struct{}
+	waiting int64
+}
+
+type Stats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"inFlight"`
+	Waiting  int64 `json:"waiting"`
+}
+
+func NewSemaphore(limit int) *Semaphore {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Semaphore{slots: make(chan struct{}, limit)}
+}
+
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	atomic.AddInt64(&s.waiting, 1)
+	defer atomic.AddInt64(&s.waiting, -1)
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Semaphore) Release() {
+	<-s.slots
+}
+
+func (s *Semaphore) Stats() Stats {
+	return Stats{
+		Limit:    cap(s.slots),
+		InFlight: len(s.slots),
+		Waiting:  atomic.LoadInt64(&s.waiting),
+	}
+}
+
+// Transport is an http.RoundTripper that holds a semaphore slot from the
+// moment a request is sent until its response body is closed, so every
+// client sharing the semaphore counts against the same limit.
+type Transport struct {
+	Base      http.RoundTripper
+	Semaphore *Semaphore
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Semaphore.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		t.Semaphore.Release()
+		return nil, err
+	}
+	res.Body = &releasingBody{ReadCloser: res.Body, release: t.Semaphore.Release}
+	return res, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// Run calls fn for every item using at most workers goroutines and returns
+// once all items are done.
+func Run[T any](workers int, items []T, fn func(T)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	queue := make(chan T)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+}
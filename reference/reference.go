@@ -0,0 +1,223 @@
+package reference
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"action_users/constants"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Currency is a wallet and action currencyId with its ISO code and, when
+// known, its Rate: the value of one unit in the reporting currency.
+type Currency struct {
+	Id   int     `json:"id" yaml:"id"`
+	Code string  `json:"code" yaml:"code"`
+	Rate float64 `json:"rate,omitempty" yaml:"rate"`
+}
+
+// Country is a user.countryId with its name and, when it has one, the
+// currency its clients are reported in.
+type Country struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	CurrencyId int    `json:"currencyId,omitempty"`
+}
+
+// Table is the reference data for countries and currencies. It starts from
+// the tables in constants and can be extended from a file.
+type Table struct {
+	countries  map[int]Country
+	currencies map[int]Currency
+	groups     map[string][]int
+	// reporting is the currency amounts are converted to, 0 when none is
+	// configured.
+	reporting int
+}
+
+// file is the layout of a reference file. Entries override the built-in
+// ones with the same id.
+type file struct {
+	Countries         map[int]string `json:"countries" yaml:"countries"`
+	Currencies        []Currency     `json:"currencies" yaml:"currencies"`
+	CountryCurrencies map[int]int    `json:"countryCurrencies" yaml:"countryCurrencies"`
+	ReportingCurrency string         `json:"reportingCurrency" yaml:"reportingCurrency"`
+}
+
+// Default is the table built from constants alone.
+func Default() *Table {
+	t := &Table{
+		countries:  make(map[int]Country, len(constants.Countries)),
+		currencies: make(map[int]Currency, len(constants.Currencies)),
+		groups:     map[string][]int{},
+	}
+	for id, name := range constants.Countries {
+		t.countries[id] = Country{Id: id, Name: name}
+	}
+	for id, code := range constants.Currencies {
+		t.currencies[id] = Currency{Id: id, Code: code}
+	}
+	for countryId, currencyId := range constants.CountryCurrencies {
+		c := t.countries[countryId]
+		c.Id = countryId
+		c.CurrencyId = currencyId
+		t.countries[countryId] = c
+	}
+	return t
+}
+
+// Load is Default extended with the YAML or JSON file at path.
+func Load(path string) (*Table, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference table: %w", err)
+	}
+
+	var f file
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &f)
+	default:
+		err = json.Unmarshal(raw, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference table %s: %v", path, err)
+	}
+
+	t := Default()
+	for id, name := range f.Countries {
+		c := t.countries[id]
+		c.Id = id
+		c.Name = name
+		t.countries[id] = c
+	}
+	for _, cur := range f.Currencies {
+		if cur.Id <= 0 || cur.Code == "" {
+			return nil, fmt.Errorf("reference table %s: currency needs an id and a code", path)
+		}
+		cur.Code = strings.ToUpper(cur.Code)
+		t.currencies[cur.Id] = cur
+	}
+	for countryId, currencyId := range f.CountryCurrencies {
+		if _, ok := t.currencies[currencyId]; !ok {
+			return nil, fmt.Errorf("reference table %s: country %d uses unknown currency %d", path, countryId, currencyId)
+		}
+		c := t.countries[countryId]
+		c.Id = countryId
+		c.CurrencyId = currencyId
+		t.countries[countryId] = c
+	}
+	if f.ReportingCurrency != "" {
+		cur, ok := t.currencyByCode(f.ReportingCurrency)
+		if !ok {
+			return nil, fmt.Errorf("reference table %s: unknown reporting currency %s", path, f.ReportingCurrency)
+		}
+		t.reporting = cur.Id
+	}
+	return t, nil
+}
+
+func (t *Table) currencyByCode(code string) (Currency, bool) {
+	for _, cur := range t.currencies {
+		if strings.EqualFold(cur.Code, code) {
+			return cur, true
+		}
+	}
+	return Currency{}, false
+}
+
+// SetGroups replaces the named country groups, keyed in lower case.
+func (t *Table) SetGroups(groups map[string][]int) {
+	t.groups = make(map[string][]int, len(groups))
+	for name, ids := range groups {
+		sorted := append([]int(nil), ids...)
+		sort.Ints(sorted)
+		t.groups[strings.ToLower(name)] = sorted
+	}
+}
+
+func (t *Table) Group(name string) ([]int, bool) {
+	ids, ok := t.groups[strings.ToLower(name)]
+	return ids, ok
+}
+
+func (t *Table) Groups() map[string][]int {
+	return t.groups
+}
+
+func (t *Table) Country(id int) (Country, bool) {
+	c, ok := t.countries[id]
+	return c, ok
+}
+
+// CountryName is the name of a country, empty when it is unknown.
+func (t *Table) CountryName(id int) string {
+	return t.countries[id].Name
+}
+
+func (t *Table) Currency(id int) (Currency, bool) {
+	c, ok := t.currencies[id]
+	return c, ok
+}
+
+// CurrencyCode is the ISO code of a currency, empty when it is unknown.
+func (t *Table) CurrencyCode(id int) string {
+	return t.currencies[id].Code
+}
+
+// ReportingCurrency is the single currency Convert brings amounts of every
+// country to, if one is configured; CountryCurrency is per country.
+func (t *Table) ReportingCurrency() (Currency, bool) {
+	cur, ok := t.currencies[t.reporting]
+	return cur, ok && t.reporting != 0
+}
+
+// Convert converts amount in currencyId to the reporting currency. It fails
+// when no reporting currency is configured or currencyId has no rate.
+func (t *Table) Convert(amount float64, currencyId int) (float64, bool) {
+	if t.reporting == 0 {
+		return 0, false
+	}
+	if currencyId == t.reporting {
+		return amount, true
+	}
+	cur, ok := t.currencies[currencyId]
+	if !ok || cur.Rate <= 0 {
+		return 0, false
+	}
+	return amount * cur.Rate, true
+}
+
+// CountryCurrency is the reporting currency of a country, if it has one.
+func (t *Table) CountryCurrency(countryId int) (int, bool) {
+	c, ok := t.countries[countryId]
+	if !ok || c.CurrencyId == 0 {
+		return 0, false
+	}
+	return c.CurrencyId, true
+}
+
+// Countries lists every country ordered by id.
+func (t *Table) Countries() []Country {
+	out := make([]Country, 0, len(t.countries))
+	for _, c := range t.countries {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	return out
+}
+
+// Currencies lists every currency ordered by id.
+func (t *Table) Currencies() []Currency {
+	out := make([]Currency, 0, len(t.currencies))
+	for _, c := range t.currencies {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	return out
+}
@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"action_users/constants"
+	"action_users/models"
+	"fmt"
+	"strconv"
+)
+
+// DecodeAction reads an action document of index. The amount, currency,
+// status and timestamp live under the index's root key from
+// constants.Indices; documents that keep createdAt at the top level are
+// accepted as well.
+func DecodeAction(index, id string, source map[string]interface{}) models.Action {
+	action := models.Action{
+		Type:  constants.ActionTypes[index],
+		Index: index,
+		Id:    id,
+	}
+
+	if user, ok := source["user"].(map[string]interface{}); ok {
+		action.UserId = formatId(user["id"])
+		if ci, ok := user["countryId"].(float64); ok {
+			action.CountryId = int(ci)
+		}
+	}
+
+	details, _ := source[constants.Indices[index]].(map[string]interface{})
+	if details == nil {
+		details = source
+	}
+
+	action.Amount = toFloat(details["amount"])
+	action.CurrencyId = int(toFloat(details["currencyId"]))
+	action.Status = formatId(details["status"])
+	action.CreatedAt = int64(toFloat(details["createdAt"]))
+	if action.CreatedAt == 0 {
+		action.CreatedAt = int64(toFloat(source["createdAt"]))
+	}
+	return action
+}
+
+func decodeHits(index string, hits []models.Hit) []models.Action {
+	var out []models.Action
+	for _, h := range hits {
+		out = append(out, DecodeAction(index, h.Id, h.Source))
+	}
+	return out
+}
+
+func toFloat(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
+func formatId(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return fmt.Sprintf("%.0f", t)
+	}
+	return ""
+}
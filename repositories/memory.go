@@ -0,0 +1,479 @@
+package repositories
+
+import (
+	"action_users/constants"
+	"action_users/models"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fixtures is the JSON layout MemoryStore is seeded from: client documents as
+// stored in clients-searcher and action documents keyed by index name.
+type Fixtures struct {
+	Clients []map[string]interface{}            `json:"clients"`
+	Actions map[string][]map[string]interface{} `json:"actions"`
+}
+
+// MemoryStore is a Store over fixed in-memory documents that answers queries
+// the same way the OpenSearch indices do, for running the service offline.
+type MemoryStore struct {
+	clients []map[string]interface{}
+	actions map[string][]models.Action
+
+	mu       sync.Mutex
+	segments map[string]map[string]models.SegmentDocument
+}
+
+func NewMemoryStore(fixtures Fixtures) *MemoryStore {
+	clients := append([]map[string]interface{}(nil), fixtures.Clients...)
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clientUserId(clients[i]) < clientUserId(clients[j])
+	})
+
+	actions := map[string][]models.Action{}
+	for index, srcs := range fixtures.Actions {
+		if _, ok := constants.Indices[index]; !ok {
+			continue
+		}
+		decoded := make([]models.Action, 0, len(srcs))
+		for i, src := range srcs {
+			decoded = append(decoded, DecodeAction(index, fmt.Sprintf("%s-%d", index, i), src))
+		}
+		sort.SliceStable(decoded, func(i, j int) bool {
+			return decoded[i].CreatedAt > decoded[j].CreatedAt
+		})
+		actions[index] = decoded
+	}
+
+	return &MemoryStore{
+		clients:  clients,
+		actions:  actions,
+		segments: map[string]map[string]models.SegmentDocument{},
+	}
+}
+
+func LoadMemoryStore(path string) (*MemoryStore, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	var fixtures Fixtures
+	if err := json.Unmarshal(raw, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures %s: %v", path, err)
+	}
+	return NewMemoryStore(fixtures), nil
+}
+
+func clientUserId(source map[string]interface{}) float64 {
+	if stats, ok := source["stats"].(map[string]interface{}); ok {
+		if id, ok := stats["userId"].(float64); ok {
+			return id
+		}
+	}
+	return 0
+}
+
+func nestedFloat(source map[string]interface{}, key, field string) (float64, bool) {
+	obj, ok := source[key].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	v, ok := obj[field].(float64)
+	return v, ok
+}
+
+func anyWalletInRange(source map[string]interface{}, from, to *float64) bool {
+	wallets, _ := source["wallets"].([]interface{})
+	for _, w := range wallets {
+		wallet, ok := w.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		balance, ok := wallet["balance"].(float64)
+		if !ok {
+			continue
+		}
+		if (from == nil || balance >= *from) && (to == nil || balance <= *to) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientMatches is the in-memory equivalent of userIdsQuery.
+func clientMatches(src map[string]interface{}, filter models.ClientFilter) bool {
+	if !filter.Countries.IsAll() {
+		if ci, ok := nestedFloat(src, "user", "countryId"); !ok || !filter.Countries.Contains(int(ci)) {
+			return false
+		}
+	}
+	if filter.CreatedBefore != 0 {
+		if ca, ok := nestedFloat(src, "user", "createdAt"); ok && int64(ca) > filter.CreatedBefore {
+			return false
+		}
+	}
+	if filter.CreatedFrom != 0 || filter.CreatedTo != 0 {
+		ca, ok := nestedFloat(src, "user", "createdAt")
+		if !ok || (filter.CreatedFrom != 0 && int64(ca) < filter.CreatedFrom) || (filter.CreatedTo != 0 && int64(ca) > filter.CreatedTo) {
+			return false
+		}
+	}
+	if len(filter.Platforms) > 0 {
+		if p, ok := nestedFloat(src, "stats", "platform"); !ok || !slices.Contains(filter.Platforms, int(p)) {
+			return false
+		}
+	}
+	if len(filter.States) > 0 {
+		if st, ok := nestedFloat(src, "user", "state"); !ok || !slices.Contains(filter.States, int(st)) {
+			return false
+		}
+	}
+	if (filter.BalanceFrom != nil || filter.BalanceTo != nil) && !anyWalletInRange(src, filter.BalanceFrom, filter.BalanceTo) {
+		return false
+	}
+	return true
+}
+
+func (s *MemoryStore) matchingClients(filter models.ClientFilter) []models.Hit {
+	var hits []models.Hit
+	for _, src := range s.clients {
+		if !clientMatches(src, filter) {
+			continue
+		}
+		hits = append(hits, models.Hit{
+			Index:  "clients-searcher",
+			Source: src,
+			Sort:   []interface{}{clientUserId(src)},
+		})
+	}
+	return hits
+}
+
+func (s *MemoryStore) GetUserIds(from, size int, filter models.ClientFilter) ([]string, error) {
+	hits := s.matchingClients(filter)
+	if from >= len(hits) {
+		return nil, nil
+	}
+	end := from + size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	return hitUserIds(hits[from:end]), nil
+}
+
+func (s *MemoryStore) GetUserIdsAfter(cursor models.Cursor, size int, filter models.ClientFilter) ([]string, *models.Cursor, error) {
+	hits := s.matchingClients(filter)
+	if len(cursor.SearchAfter) > 0 {
+		after, ok := cursor.SearchAfter[0].(float64)
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected search_after value %v", cursor.SearchAfter[0])
+		}
+		start := sort.Search(len(hits), func(i int) bool {
+			return clientUserId(hits[i].Source) > after
+		})
+		hits = hits[start:]
+	}
+
+	if len(hits) < size {
+		return hitUserIds(hits), nil, nil
+	}
+	hits = hits[:size]
+	next := &models.Cursor{PitId: "memory", SearchAfter: hits[len(hits)-1].Sort}
+	return hitUserIds(hits), next, nil
+}
+
+func (s *MemoryStore) ReleaseCursor(cursor models.Cursor) error {
+	return nil
+}
+
+func (s *MemoryStore) GetClientById(userIdStr string, countries models.Countries) (map[string]interface{}, error) {
+	userIdInt, err := toInt64(userIdStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range s.clients {
+		if int64(clientUserId(src)) == userIdInt {
+			return src, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) GetActionsFromIndex(userIdStr string, index string, size int, countries models.Countries, until int64) ([]models.Action, error) {
+	if _, err := toInt64(userIdStr); err != nil {
+		return nil, err
+	}
+
+	var out []models.Action
+	for _, act := range s.actions[index] {
+		if len(out) == size {
+			break
+		}
+		if act.UserId != userIdStr {
+			continue
+		}
+		if !countries.Contains(act.CountryId) {
+			continue
+		}
+		if until != 0 && act.CreatedAt > until {
+			continue
+		}
+		out = append(out, act)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetActionsFromIndexNoCountry(userIdStr string, index string, size int, until int64) ([]models.Action, error) {
+	return s.GetActionsFromIndex(userIdStr, index, size, models.Countries{}, until)
+}
+
+func (s *MemoryStore) GetActionsInRange(userIdStr string, index string, size int, r models.ActionRange) ([]models.Action, error) {
+	if _, err := toInt64(userIdStr); err != nil {
+		return nil, err
+	}
+
+	var out []models.Action
+	for _, act := range s.actions[index] {
+		if len(out) == size {
+			break
+		}
+		if act.UserId != userIdStr {
+			continue
+		}
+		if (r.From != 0 && act.CreatedAt < r.From) || (r.To != 0 && act.CreatedAt > r.To) {
+			continue
+		}
+		out = append(out, act)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetLastActionsForUsers(userIds []string, indicesList []string, size int, countries models.Countries, until int64) (map[string]map[string][]models.Action, error) {
+	out := map[string]map[string][]models.Action{}
+	for _, uid := range userIds {
+		for _, idx := range indicesList {
+			srcs, err := s.GetActionsFromIndex(uid, idx, size, countries, until)
+			if err != nil {
+				return nil, err
+			}
+			if len(srcs) == 0 && !countries.IsAll() {
+				srcs, err = s.GetActionsFromIndexNoCountry(uid, idx, size, until)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if len(srcs) == 0 {
+				continue
+			}
+			if out[uid] == nil {
+				out[uid] = map[string][]models.Action{}
+			}
+			out[uid][idx] = srcs
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetLastActionsAfter(cursor models.Cursor, size int, until int64) ([]models.LastAction, *models.Cursor, error) {
+	latest := map[int64]models.LastAction{}
+	var ids []int64
+	// Walk the indices in a fixed order so that ties go the same way.
+	for _, idx := range actionIndices() {
+		for _, act := range s.actions[idx] {
+			if until != 0 && act.CreatedAt > until {
+				continue
+			}
+			id, err := toInt64(act.UserId)
+			if err != nil {
+				continue
+			}
+			la, seen := latest[id]
+			if !seen {
+				ids = append(ids, id)
+			}
+			switch {
+			case !seen || act.CreatedAt > la.CreatedAt:
+				latest[id] = models.LastAction{UserId: act.UserId, Type: act.Type, CountryId: act.CountryId, CreatedAt: act.CreatedAt, PreviousAt: la.CreatedAt}
+			case act.CreatedAt > la.PreviousAt:
+				la.PreviousAt = act.CreatedAt
+				latest[id] = la
+			}
+		}
+	}
+	slices.Sort(ids)
+
+	start := 0
+	if len(cursor.SearchAfter) > 0 {
+		after := int64(toFloat(cursor.SearchAfter[0]))
+		start, _ = slices.BinarySearch(ids, after+1)
+	}
+	end := min(start+size, len(ids))
+
+	page := make([]models.LastAction, 0, end-start)
+	for _, id := range ids[start:end] {
+		page = append(page, latest[id])
+	}
+	if end == len(ids) {
+		return page, nil, nil
+	}
+	return page, &models.Cursor{SearchAfter: []interface{}{float64(ids[end-1])}}, nil
+}
+
+func (s *MemoryStore) GetClientsByIds(userIds []string) (map[string]map[string]interface{}, error) {
+	out := make(map[string]map[string]interface{}, len(userIds))
+	for _, uid := range userIds {
+		src, err := s.GetClientById(uid, models.Countries{})
+		if err != nil {
+			return nil, err
+		}
+		if src != nil {
+			out[uid] = src
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetClientBreakdown(filter models.ClientFilter) (models.ClientBreakdown, error) {
+	bd := models.ClientBreakdown{ByCountry: map[int]int{}, ByPlatform: map[int]int{}, ByCohort: map[string]int{}}
+	for _, hit := range s.matchingClients(filter) {
+		addToBreakdown(&bd, hit.Source)
+	}
+	return bd, nil
+}
+
+func addToBreakdown(bd *models.ClientBreakdown, src map[string]interface{}) {
+	country, _ := nestedFloat(src, "user", "countryId")
+	platform, _ := nestedFloat(src, "stats", "platform")
+	cohort := ""
+	if ca, ok := nestedFloat(src, "user", "createdAt"); ok {
+		cohort = time.Unix(int64(ca), 0).UTC().Format("2006-01")
+	}
+	bd.Total++
+	bd.ByCountry[int(country)]++
+	bd.ByPlatform[int(platform)]++
+	bd.ByCohort[cohort]++
+}
+
+func (s *MemoryStore) GetClientJoin(groups map[string][]string, filter models.ClientFilter) (map[string]models.ClientJoin, error) {
+	out := make(map[string]models.ClientJoin, len(groups))
+	for key, userIds := range groups {
+		join := models.ClientJoin{Matching: models.ClientBreakdown{ByCountry: map[int]int{}, ByPlatform: map[int]int{}, ByCohort: map[string]int{}}}
+		for _, uid := range userIds {
+			src, err := s.GetClientById(uid, models.Countries{})
+			if err != nil {
+				return nil, err
+			}
+			if src == nil {
+				continue
+			}
+			join.Registered++
+			if clientMatches(src, filter) {
+				addToBreakdown(&join.Matching, src)
+			}
+		}
+		out[key] = join
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetActionMonths(userIds []string, r models.ActionRange) (map[string][]string, error) {
+	wanted := make(map[string]bool, len(userIds))
+	for _, uid := range userIds {
+		wanted[uid] = true
+	}
+	seen := map[string]map[string]bool{}
+	out := map[string][]string{}
+	for _, acts := range s.actions {
+		for _, act := range acts {
+			if !wanted[act.UserId] || (r.From != 0 && act.CreatedAt < r.From) || (r.To != 0 && act.CreatedAt > r.To) {
+				continue
+			}
+			month := time.Unix(act.CreatedAt, 0).UTC().Format("2006-01")
+			if seen[act.UserId] == nil {
+				seen[act.UserId] = map[string]bool{}
+			}
+			if !seen[act.UserId][month] {
+				seen[act.UserId][month] = true
+				out[act.UserId] = append(out[act.UserId], month)
+			}
+		}
+	}
+	for _, months := range out {
+		sort.Strings(months)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetFirstActionTimes(userIds []string, index string, until int64) (map[string]int64, error) {
+	wanted := make(map[string]bool, len(userIds))
+	for _, uid := range userIds {
+		wanted[uid] = true
+	}
+	out := map[string]int64{}
+	for _, act := range s.actions[index] {
+		if !wanted[act.UserId] || (until != 0 && act.CreatedAt > until) {
+			continue
+		}
+		if first, ok := out[act.UserId]; !ok || act.CreatedAt < first {
+			out[act.UserId] = act.CreatedAt
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) GetActionStats(userIds []string, index string, ranges []models.ActionRange) (map[string][]models.ActionStats, error) {
+	wanted := make(map[string]bool, len(userIds))
+	for _, uid := range userIds {
+		wanted[uid] = true
+	}
+	out := map[string][]models.ActionStats{}
+	for _, act := range s.actions[index] {
+		if !wanted[act.UserId] {
+			continue
+		}
+		for i, r := range ranges {
+			if (r.From != 0 && act.CreatedAt < r.From) || (r.To != 0 && act.CreatedAt > r.To) {
+				continue
+			}
+			if out[act.UserId] == nil {
+				out[act.UserId] = make([]models.ActionStats, len(ranges))
+			}
+			out[act.UserId][i].Add(models.ActionStats{
+				Count:   1,
+				Amounts: map[int]float64{act.CurrencyId: act.Amount},
+				Counts:  map[int]int{act.CurrencyId: 1},
+				First:   act.CreatedAt,
+				Last:    act.CreatedAt,
+			})
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) SaveSegments(index string, docs []models.SegmentDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.segments[index] == nil {
+		s.segments[index] = map[string]models.SegmentDocument{}
+	}
+	for _, doc := range docs {
+		s.segments[index][doc.UserId] = doc
+	}
+	return nil
+}
+
+// Segments returns the documents saved to index, keyed by userId.
+func (s *MemoryStore) Segments(index string) map[string]models.SegmentDocument {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]models.SegmentDocument, len(s.segments[index]))
+	for id, doc := range s.segments[index] {
+		out[id] = doc
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"action_users/models"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+const pitKeepAlive = "5m"
+
+func perform(client *opensearch.Client, method, path string, body interface{}) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := client.Perform(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	rawBody, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("server returned status %d: %s", res.StatusCode, string(rawBody))
+	}
+	return rawBody, nil
+}
+
+func OpenPointInTime(client *opensearch.Client, index string) (string, error) {
+	path := "/" + url.PathEscape(index) + "/_search/point_in_time?keep_alive=" + pitKeepAlive
+	raw, err := perform(client, http.MethodPost, path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		PitId string `json:"pit_id"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse point in time response: %v", err)
+	}
+	if resp.PitId == "" {
+		return "", fmt.Errorf("point in time response has no pit_id")
+	}
+	return resp.PitId, nil
+}
+
+func ClosePointInTime(client *opensearch.Client, pitId string) error {
+	_, err := perform(client, http.MethodDelete, "/_search/point_in_time", map[string]interface{}{
+		"pit_id": []string{pitId},
+	})
+	return err
+}
+
+// EncodeCursor packs the point in time and search_after position into an
+// opaque token that clients pass back as the cursor query parameter.
+func EncodeCursor(cursor models.Cursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// DecodeCursor unpacks a token built by EncodeCursor and checks that it was
+// issued for scope.
+func DecodeCursor(token string, scope models.CursorScope) (models.Cursor, error) {
+	var cursor models.Cursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, fmt.Errorf("malformed cursor: %v", err)
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, fmt.Errorf("malformed cursor: %v", err)
+	}
+	if cursor.PitId == "" {
+		return cursor, fmt.Errorf("malformed cursor: missing point in time")
+	}
+	if cursor.Scope == nil {
+		return cursor, fmt.Errorf("malformed cursor: missing scope")
+	}
+	got, err := json.Marshal(cursor.Scope)
+	if err != nil {
+		return cursor, err
+	}
+	want, err := json.Marshal(scope)
+	if err != nil {
+		return cursor, err
+	}
+	if !bytes.Equal(got, want) {
+		return cursor, fmt.Errorf("cursor was issued for other query parameters")
+	}
+	return cursor, nil
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
 )
 
 func toInt64(s string) (int64, error) {
@@ -28,11 +29,16 @@ func doSearch(client *opensearch.Client, index string, query map[string]interfac
 		return nil, err
 	}
 
-	res, err := client.Search(
+	opts := []func(*opensearchapi.SearchRequest){
 		client.Search.WithContext(ctx),
-		client.Search.WithIndex(index),
 		client.Search.WithBody(bytes.NewReader(body)),
-	)
+	}
+	// Point in time searches carry the target in the body and must not name an index.
+	if index != "" {
+		opts = append(opts, client.Search.WithIndex(index))
+	}
+
+	res, err := client.Search(opts...)
 	if err != nil {
 		log.Printf("Search request error: %v", err)
 		return nil, err
@@ -53,40 +59,176 @@ func doSearch(client *opensearch.Client, index string, query map[string]interfac
 	return &sr, nil
 }
 
-func GetUserIds(client *opensearch.Client, from, size, countryId int) ([]string, error) {
-	query := map[string]interface{}{
-		"_source": []string{"stats.userId"},
-		"from":    from,
-		"size":    size,
-	}
-	if countryId != 0 {
-		query["query"] = map[string]interface{}{
+func userIdsQuery(filter models.ClientFilter) map[string]interface{} {
+	must := countryClauses(filter.Countries)
+	if filter.CreatedBefore != 0 {
+		// Documents without createdAt stay in: they cannot be placed in time.
+		must = append(must, map[string]interface{}{
 			"bool": map[string]interface{}{
-				"must": []map[string]interface{}{
-					{"term": map[string]interface{}{"user.countryId": countryId}},
+				"should": []map[string]interface{}{
+					{"range": map[string]interface{}{"user.createdAt": map[string]interface{}{"lte": filter.CreatedBefore}}},
+					{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]string{"field": "user.createdAt"}}}},
 				},
+				"minimum_should_match": 1,
 			},
+		})
+	}
+	if filter.CreatedFrom != 0 || filter.CreatedTo != 0 {
+		created := map[string]interface{}{}
+		if filter.CreatedFrom != 0 {
+			created["gte"] = filter.CreatedFrom
 		}
-	} else {
-		query["query"] = map[string]interface{}{"match_all": map[string]interface{}{}}
+		if filter.CreatedTo != 0 {
+			created["lte"] = filter.CreatedTo
+		}
+		must = append(must, map[string]interface{}{"range": map[string]interface{}{"user.createdAt": created}})
+	}
+	if len(filter.Platforms) > 0 {
+		must = append(must, map[string]interface{}{"terms": map[string]interface{}{"stats.platform": filter.Platforms}})
+	}
+	if len(filter.States) > 0 {
+		must = append(must, map[string]interface{}{"terms": map[string]interface{}{"user.state": filter.States}})
+	}
+	if filter.BalanceFrom != nil || filter.BalanceTo != nil {
+		balance := map[string]interface{}{}
+		if filter.BalanceFrom != nil {
+			balance["gte"] = *filter.BalanceFrom
+		}
+		if filter.BalanceTo != nil {
+			balance["lte"] = *filter.BalanceTo
+		}
+		must = append(must, map[string]interface{}{"range": map[string]interface{}{"wallets.balance": balance}})
 	}
 
-	sr, err := doSearch(client, "clients-searcher", query)
-	if err != nil {
-		return nil, err
+	if len(must) == 0 {
+		return map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": must,
+		},
 	}
+}
+
+// countryClauses are the must clauses selecting countries by user.countryId,
+// which clients-searcher and the action indices share.
+func countryClauses(countries models.Countries) []map[string]interface{} {
+	var must []map[string]interface{}
+	if len(countries.Include) > 0 {
+		must = append(must, map[string]interface{}{"terms": map[string]interface{}{"user.countryId": countries.Include}})
+	}
+	if len(countries.Exclude) > 0 {
+		must = append(must, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": map[string]interface{}{"terms": map[string]interface{}{"user.countryId": countries.Exclude}},
+			},
+		})
+	}
+	return must
+}
+
+// untilClause limits an action query to documents created at or before
+// until; 0 means no limit.
+func untilClause(index string, until int64) []map[string]interface{} {
+	return rangeClause(index, models.ActionRange{To: until})
+}
+
+func rangeClause(index string, r models.ActionRange) []map[string]interface{} {
+	bounds := map[string]interface{}{}
+	if r.From != 0 {
+		bounds["gte"] = r.From
+	}
+	if r.To != 0 {
+		bounds["lte"] = r.To
+	}
+	if len(bounds) == 0 {
+		return nil
+	}
+	return []map[string]interface{}{
+		{"range": map[string]interface{}{constants.Indices[index] + ".createdAt": bounds}},
+	}
+}
+
+func hitUserIds(hits []models.Hit) []string {
 	var ids []string
-	for _, hit := range sr.Hits.Hits {
+	for _, hit := range hits {
 		if stats, ok := hit.Source["stats"].(map[string]interface{}); ok {
 			if idf, ok := stats["userId"].(float64); ok {
 				ids = append(ids, fmt.Sprintf("%.0f", idf))
 			}
 		}
 	}
-	return ids, nil
+	return ids
+}
+
+func GetUserIds(client *opensearch.Client, from, size int, filter models.ClientFilter) ([]string, error) {
+	query := map[string]interface{}{
+		"_source": []string{"stats.userId"},
+		"from":    from,
+		"size":    size,
+		"query":   userIdsQuery(filter),
+	}
+
+	sr, err := doSearch(client, "clients-searcher", query)
+	if err != nil {
+		return nil, err
+	}
+	return hitUserIds(sr.Hits.Hits), nil
+}
+
+// GetUserIdsAfter pages clients-searcher through a point in time sorted by
+// stats.userId, so the walk is not capped by max_result_window and does not
+// shift while the index changes. An empty cursor.PitId opens a new point in
+// time. The returned cursor is nil once the last page has been read; the
+// point in time is closed at that moment.
+func GetUserIdsAfter(client *opensearch.Client, cursor models.Cursor, size int, filter models.ClientFilter) ([]string, *models.Cursor, error) {
+	if cursor.PitId == "" {
+		pitId, err := OpenPointInTime(client, "clients-searcher")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open point in time: %w", err)
+		}
+		cursor = models.Cursor{PitId: pitId}
+	}
+
+	query := map[string]interface{}{
+		"_source": []string{"stats.userId"},
+		"size":    size,
+		"query":   userIdsQuery(filter),
+		"pit": map[string]interface{}{
+			"id":         cursor.PitId,
+			"keep_alive": pitKeepAlive,
+		},
+		"sort": []map[string]interface{}{
+			{"stats.userId": map[string]string{"order": "asc"}},
+		},
+	}
+	if len(cursor.SearchAfter) > 0 {
+		query["search_after"] = cursor.SearchAfter
+	}
+
+	sr, err := doSearch(client, "", query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hits := sr.Hits.Hits
+	pitId := cursor.PitId
+	if sr.PitId != "" {
+		pitId = sr.PitId
+	}
+
+	if len(hits) < size {
+		if err := ClosePointInTime(client, pitId); err != nil {
+			log.Printf("warn: failed to close point in time: %v", err)
+		}
+		return hitUserIds(hits), nil, nil
+	}
+
+	next := &models.Cursor{PitId: pitId, SearchAfter: hits[len(hits)-1].Sort}
+	return hitUserIds(hits), next, nil
 }
 
-func GetClientById(client *opensearch.Client, userIdStr string, countryId int) (map[string]interface{}, error) {
+func GetClientById(client *opensearch.Client, userIdStr string, countries models.Countries) (map[string]interface{}, error) {
 	userIdInt, err := toInt64(userIdStr)
 	if err != nil {
 		return nil, err
@@ -115,7 +257,7 @@ func GetClientById(client *opensearch.Client, userIdStr string, countryId int) (
 			log.Printf("warn: user document missing createdAt for userId: %s", userIdStr)
 		}
 
-		if countryId != 0 {
+		if !countries.IsAll() {
 			log.Printf("debug: client found for %s (country filter ignored due to mismatch or absence)", userIdStr)
 		}
 		return source, nil
@@ -124,19 +266,22 @@ func GetClientById(client *opensearch.Client, userIdStr string, countryId int) (
 	return nil, nil
 }
 
-func GetActionsFromIndexNoCountry(client *opensearch.Client, userIdStr string, index string, size int) ([]map[string]interface{}, error) {
+func GetActionsFromIndexNoCountry(client *opensearch.Client, userIdStr string, index string, size int, until int64) ([]models.Action, error) {
 	userIdInt, err := toInt64(userIdStr)
 	if err != nil {
 		return nil, err
 	}
 
+	must := []map[string]interface{}{
+		{"term": map[string]interface{}{"user.id": userIdInt}},
+	}
+	must = append(must, untilClause(index, until)...)
+
 	query := map[string]interface{}{
 		"size": size,
 		"query": map[string]interface{}{
 			"bool": map[string]interface{}{
-				"must": []map[string]interface{}{
-					{"term": map[string]interface{}{"user.id": userIdInt}},
-				},
+				"must": must,
 			},
 		},
 		"sort": []map[string]interface{}{
@@ -148,14 +293,12 @@ func GetActionsFromIndexNoCountry(client *opensearch.Client, userIdStr string, i
 	if err != nil {
 		return nil, err
 	}
-	var out []map[string]interface{}
-	for _, h := range sr.Hits.Hits {
-		out = append(out, h.Source)
-	}
-	return out, nil
+	return decodeHits(index, sr.Hits.Hits), nil
 }
 
-func GetActionsFromIndex(client *opensearch.Client, userIdStr string, index string, size, countryId int) ([]map[string]interface{}, error) {
+// GetActionsInRange returns up to size actions of a user from one index
+// created within r, newest first, regardless of country.
+func GetActionsInRange(client *opensearch.Client, userIdStr string, index string, size int, r models.ActionRange) ([]models.Action, error) {
 	userIdInt, err := toInt64(userIdStr)
 	if err != nil {
 		return nil, err
@@ -164,10 +307,39 @@ func GetActionsFromIndex(client *opensearch.Client, userIdStr string, index stri
 	must := []map[string]interface{}{
 		{"term": map[string]interface{}{"user.id": userIdInt}},
 	}
-	if countryId != 0 {
-		must = append(must, map[string]interface{}{"term": map[string]interface{}{"user.countryId": countryId}})
+	must = append(must, rangeClause(index, r)...)
+
+	query := map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": must,
+			},
+		},
+		"sort": []map[string]interface{}{
+			{constants.Indices[index] + ".createdAt": map[string]string{"order": "desc"}},
+		},
+	}
+
+	sr, err := doSearch(client, index, query)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHits(index, sr.Hits.Hits), nil
+}
+
+func GetActionsFromIndex(client *opensearch.Client, userIdStr string, index string, size int, countries models.Countries, until int64) ([]models.Action, error) {
+	userIdInt, err := toInt64(userIdStr)
+	if err != nil {
+		return nil, err
 	}
 
+	must := []map[string]interface{}{
+		{"term": map[string]interface{}{"user.id": userIdInt}},
+	}
+	must = append(must, countryClauses(countries)...)
+	must = append(must, untilClause(index, until)...)
+
 	sortField := constants.Indices[index] + ".createdAt"
 	query := map[string]interface{}{
 		"size": size,
@@ -185,26 +357,177 @@ func GetActionsFromIndex(client *opensearch.Client, userIdStr string, index stri
 	if err != nil {
 		return nil, err
 	}
-	var out []map[string]interface{}
-	for _, h := range sr.Hits.Hits {
-		out = append(out, h.Source)
+	return decodeHits(index, sr.Hits.Hits), nil
+}
+
+func actionsByUserQuery(userIds []int64, index string, size int, countries models.Countries, until int64) map[string]interface{} {
+	must := []map[string]interface{}{
+		{"terms": map[string]interface{}{"user.id": userIds}},
+	}
+	must = append(must, countryClauses(countries)...)
+	must = append(must, untilClause(index, until)...)
+
+	return map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": must,
+			},
+		},
+		"aggs": map[string]interface{}{
+			"users": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": "user.id",
+					"size":  len(userIds),
+				},
+				"aggs": map[string]interface{}{
+					"latest": map[string]interface{}{
+						"top_hits": map[string]interface{}{
+							"size": size,
+							"sort": []map[string]interface{}{
+								{constants.Indices[index] + ".createdAt": map[string]string{"order": "desc"}},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
-	return out, nil
 }
 
-func GetCreatedAt(source map[string]interface{}) int64 {
-	if source == nil {
-		return 0
+func doMsearch(client *opensearch.Client, indices []string, queries []map[string]interface{}) (*models.MsearchResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i, q := range queries {
+		if err := enc.Encode(map[string]interface{}{"index": indices[i]}); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(q); err != nil {
+			return nil, err
+		}
 	}
-	for _, key := range []string{"entity", "withdrawal", "bet", "card"} {
-		if obj, ok := source[key].(map[string]interface{}); ok {
-			if ts, ok := obj["createdAt"].(float64); ok {
-				return int64(ts)
+
+	res, err := client.Msearch(
+		&buf,
+		client.Msearch.WithContext(ctx),
+	)
+	if err != nil {
+		log.Printf("Msearch request error: %v", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	rawBody, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("server returned status %d: %s", res.StatusCode, string(rawBody))
+	}
+
+	var mr models.MsearchResponse
+	if err := json.Unmarshal(rawBody, &mr); err != nil {
+		return nil, fmt.Errorf("failed to parse msearch response: %v", err)
+	}
+	if len(mr.Responses) != len(queries) {
+		return nil, fmt.Errorf("msearch returned %d responses for %d queries", len(mr.Responses), len(queries))
+	}
+
+	return &mr, nil
+}
+
+// GetLastActionsForUsers resolves the latest size actions of every user in
+// userIds for each of indicesList with a single _msearch of terms+top_hits
+// aggregations. Like GetActionsFromIndex followed by
+// GetActionsFromIndexNoCountry, users with nothing in the country-filtered
+// search of an index are looked up again in that index without the country.
+// Actions created after until are ignored unless until is 0. The result is
+// keyed by userId and then by index.
+func GetLastActionsForUsers(client *opensearch.Client, userIds []string, indicesList []string, size int, countries models.Countries, until int64) (map[string]map[string][]models.Action, error) {
+	out := map[string]map[string][]models.Action{}
+	if len(userIds) == 0 || len(indicesList) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(userIds))
+	for _, uid := range userIds {
+		id, err := toInt64(uid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	queries := make([]map[string]interface{}, 0, len(indicesList))
+	for _, idx := range indicesList {
+		queries = append(queries, actionsByUserQuery(ids, idx, size, countries, until))
+	}
+
+	mr, err := doMsearch(client, indicesList, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	var retryIndices []string
+	var retryQueries []map[string]interface{}
+	for i, idx := range indicesList {
+		resp := mr.Responses[i]
+		if resp.Error != nil {
+			log.Printf("warn: msearch %s err: %v", idx, resp.Error)
+		} else {
+			collectLatestActions(out, idx, resp)
+		}
+
+		if countries.IsAll() && resp.Error == nil {
+			continue
+		}
+		var missing []int64
+		for j, uid := range userIds {
+			if len(out[uid][idx]) == 0 {
+				missing = append(missing, ids[j])
 			}
 		}
+		if len(missing) > 0 {
+			retryIndices = append(retryIndices, idx)
+			retryQueries = append(retryQueries, actionsByUserQuery(missing, idx, size, models.Countries{}, until))
+		}
+	}
+
+	if len(retryQueries) == 0 {
+		return out, nil
+	}
+
+	mr, err = doMsearch(client, retryIndices, retryQueries)
+	if err != nil {
+		return nil, err
 	}
-	if ts, ok := source["createdAt"].(float64); ok {
-		return int64(ts)
+	for i, idx := range retryIndices {
+		resp := mr.Responses[i]
+		if resp.Error != nil {
+			log.Printf("warn: msearch %s (no country) err: %v", idx, resp.Error)
+			continue
+		}
+		collectLatestActions(out, idx, resp)
+	}
+
+	return out, nil
+}
+
+func collectLatestActions(out map[string]map[string][]models.Action, index string, resp models.MsearchItem) {
+	for _, bucket := range resp.Aggregations.Users.Buckets {
+		uid := formatId(bucket.Key)
+		if uid == "" {
+			continue
+		}
+
+		acts := decodeHits(index, bucket.Latest.Hits.Hits)
+		if len(acts) == 0 {
+			continue
+		}
+		if out[uid] == nil {
+			out[uid] = map[string][]models.Action{}
+		}
+		out[uid][index] = acts
 	}
-	return 0
 }
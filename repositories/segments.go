@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"action_users/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+const segmentsIndexPrefix = "user-activity-segments-"
+
+// SegmentsIndexName is the dated index segmentation results computed at t
+// are written to.
+func SegmentsIndexName(t time.Time) string {
+	return segmentsIndexPrefix + t.Format("2006.01.02")
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Id     string      `json:"_id"`
+		Status int         `json:"status"`
+		Error  interface{} `json:"error,omitempty"`
+	} `json:"items"`
+}
+
+// IndexSegments bulk-indexes docs into index with the userId as document ID,
+// so re-running a segmentation on the same day overwrites instead of
+// duplicating.
+func IndexSegments(client *opensearch.Client, index string, docs []models.SegmentDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, doc := range docs {
+		if err := enc.Encode(map[string]interface{}{
+			"index": map[string]string{"_index": index, "_id": doc.UserId},
+		}); err != nil {
+			return err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+
+	res, err := client.Bulk(
+		&buf,
+		client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		log.Printf("Bulk request error: %v", err)
+		return err
+	}
+	defer res.Body.Close()
+
+	rawBody, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("server returned status %d: %s", res.StatusCode, string(rawBody))
+	}
+
+	var br bulkResponse
+	if err := json.Unmarshal(rawBody, &br); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %v", err)
+	}
+	if !br.Errors {
+		return nil
+	}
+
+	failed := 0
+	var firstErr interface{}
+	for _, item := range br.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = result.Error
+				}
+			}
+		}
+	}
+	return fmt.Errorf("%d of %d segment documents failed to index, first error: %v", failed, len(docs), firstErr)
+}
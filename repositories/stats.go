@@ -0,0 +1,671 @@
+package repositories
+
+import (
+	"action_users/constants"
+	"action_users/models"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// cohortScript buckets clients by registration month; user.createdAt holds
+// unix seconds, which date_histogram would read as milliseconds.
+const cohortScript = `doc['user.createdAt'].size() == 0 ? '' : ` +
+	`java.time.Instant.ofEpochSecond(doc['user.createdAt'].value).atZone(java.time.ZoneOffset.UTC)` +
+	`.format(java.time.format.DateTimeFormatter.ofPattern('yyyy-MM'))`
+
+// createdAtScript reads createdAt under whichever of params.roots the
+// document has.
+const createdAtScript = `for (def root : params.roots) { def f = root + '.createdAt'; ` +
+	`if (doc.containsKey(f) && doc[f].size() > 0) { return doc[f].value; } } return 0;`
+
+// actionMonthScript buckets actions by month (UTC) of createdAt under
+// whichever of params.roots the document has.
+const actionMonthScript = `for (def root : params.roots) { def f = root + '.createdAt'; ` +
+	`if (doc.containsKey(f) && doc[f].size() > 0) { return java.time.Instant.ofEpochSecond(doc[f].value)` +
+	`.atZone(java.time.ZoneOffset.UTC).format(java.time.format.DateTimeFormatter.ofPattern('yyyy-MM')); } } ` +
+	`return '';`
+
+func actionIndices() []string {
+	indices := make([]string, 0, len(constants.Indices))
+	for idx := range constants.Indices {
+		indices = append(indices, idx)
+	}
+	sort.Strings(indices)
+	return indices
+}
+
+// anyRangeClause is rangeClause for a search over all action indices, whose
+// createdAt lives under a different root in each of them.
+func anyRangeClause(r models.ActionRange) []map[string]interface{} {
+	if r.From == 0 && r.To == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	var should []map[string]interface{}
+	for _, idx := range actionIndices() {
+		root := constants.Indices[idx]
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+		should = append(should, rangeClause(idx, r)...)
+	}
+	return []map[string]interface{}{
+		{"bool": map[string]interface{}{"should": should, "minimum_should_match": 1}},
+	}
+}
+
+// actionRoots lists the distinct document roots of the action indices.
+func actionRoots() []string {
+	seen := map[string]bool{}
+	var roots []string
+	for _, idx := range actionIndices() {
+		if root := constants.Indices[idx]; !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// lastActionAggs reads the latest createdAt of the documents of a bucket
+// and, ordered by it, the index and user.countryId of the latest one.
+func lastActionAggs() map[string]interface{} {
+	last := map[string]interface{}{
+		"max": map[string]interface{}{
+			"script": map[string]interface{}{
+				"source": createdAtScript,
+				"lang":   "painless",
+				"params": map[string]interface{}{"roots": actionRoots()},
+			},
+		},
+	}
+	latestBy := func(field string) map[string]interface{} {
+		terms := map[string]interface{}{"field": field, "size": 1, "order": map[string]string{"last": "desc"}}
+		if field != "_index" {
+			terms["missing"] = 0
+		}
+		return map[string]interface{}{
+			"terms": terms,
+			"aggs":  map[string]interface{}{"last": last},
+		}
+	}
+	return map[string]interface{}{
+		"last":      last,
+		"index":     latestBy("_index"),
+		"countryId": latestBy("user.countryId"),
+	}
+}
+
+// actionTypeOf is the action type of the documents of index, which may be
+// a concrete index behind one of constants.Indices.
+func actionTypeOf(index string) models.ActionType {
+	if t, ok := constants.ActionTypes[index]; ok {
+		return t
+	}
+	for _, idx := range actionIndices() {
+		if strings.HasPrefix(index, idx) {
+			return constants.ActionTypes[idx]
+		}
+	}
+	return ""
+}
+
+// GetLastActionsAfter pages through every user with at least one action in
+// the indices of constants.Indices, in ascending order of id, with a
+// composite aggregation that also reads the time, type and country of each
+// user's latest action and the time of the one before it. The returned
+// cursor is nil after the last page.
+func GetLastActionsAfter(client *opensearch.Client, cursor models.Cursor, size int, until int64) ([]models.LastAction, *models.Cursor, error) {
+	must := anyRangeClause(models.ActionRange{To: until})
+
+	composite := map[string]interface{}{
+		"size": size,
+		"sources": []map[string]interface{}{
+			{"user": map[string]interface{}{"terms": map[string]interface{}{"field": "user.id"}}},
+		},
+	}
+	if len(cursor.SearchAfter) > 0 {
+		composite["after"] = map[string]interface{}{"user": cursor.SearchAfter[0]}
+	}
+
+	aggs := lastActionAggs()
+	// The sort values of the two latest documents carry their createdAt.
+	aggs["recent"] = map[string]interface{}{
+		"top_hits": map[string]interface{}{
+			"size":    2,
+			"_source": false,
+			"sort": []map[string]interface{}{
+				{"_script": map[string]interface{}{
+					"type":  "number",
+					"order": "desc",
+					"script": map[string]interface{}{
+						"source": createdAtScript,
+						"lang":   "painless",
+						"params": map[string]interface{}{"roots": actionRoots()},
+					},
+				}},
+			},
+		},
+	}
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": must,
+			},
+		},
+		"aggs": map[string]interface{}{
+			"users": map[string]interface{}{
+				"composite": composite,
+				"aggs":      aggs,
+			},
+		},
+	}
+
+	sr, err := doSearch(client, strings.Join(actionIndices(), ","), query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type value struct {
+		Value *float64 `json:"value"`
+	}
+	type latest struct {
+		Buckets []struct {
+			Key interface{} `json:"key"`
+		} `json:"buckets"`
+	}
+	var result struct {
+		Users struct {
+			AfterKey map[string]interface{} `json:"after_key"`
+			Buckets  []struct {
+				Key       map[string]interface{} `json:"key"`
+				Last      value                  `json:"last"`
+				Index     latest                 `json:"index"`
+				CountryId latest                 `json:"countryId"`
+				Recent    struct {
+					Hits struct {
+						Hits []struct {
+							Sort []interface{} `json:"sort"`
+						} `json:"hits"`
+					} `json:"hits"`
+				} `json:"recent"`
+			} `json:"buckets"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(sr.Aggregations, &result); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse last actions aggregation: %v", err)
+	}
+
+	out := make([]models.LastAction, 0, len(result.Users.Buckets))
+	for _, b := range result.Users.Buckets {
+		la := models.LastAction{UserId: formatId(b.Key["user"])}
+		if b.Last.Value != nil {
+			la.CreatedAt = int64(*b.Last.Value)
+		}
+		if len(b.Index.Buckets) > 0 {
+			index, _ := b.Index.Buckets[0].Key.(string)
+			la.Type = actionTypeOf(index)
+		}
+		if len(b.CountryId.Buckets) > 0 {
+			la.CountryId = int(toFloat(b.CountryId.Buckets[0].Key))
+		}
+		if hits := b.Recent.Hits.Hits; len(hits) > 1 && len(hits[1].Sort) > 0 {
+			la.PreviousAt = int64(toFloat(hits[1].Sort[0]))
+		}
+		out = append(out, la)
+	}
+	if len(result.Users.Buckets) < size || result.Users.AfterKey == nil {
+		return out, nil, nil
+	}
+	return out, &models.Cursor{SearchAfter: []interface{}{result.Users.AfterKey["user"]}}, nil
+}
+
+// GetClientsByIds returns the clients-searcher documents of userIds keyed by
+// userId; users without a document are absent.
+func GetClientsByIds(client *opensearch.Client, userIds []string) (map[string]map[string]interface{}, error) {
+	out := make(map[string]map[string]interface{}, len(userIds))
+	if len(userIds) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(userIds))
+	for _, uid := range userIds {
+		id, err := toInt64(uid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	query := map[string]interface{}{
+		"size": len(ids),
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{"stats.userId": ids},
+		},
+	}
+
+	sr, err := doSearch(client, "clients-searcher", query)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range sr.Hits.Hits {
+		out[formatId(clientUserId(hit.Source))] = hit.Source
+	}
+	return out, nil
+}
+
+// GetClientBreakdown counts the clients matching filter by country, platform
+// and registration month.
+func GetClientBreakdown(client *opensearch.Client, filter models.ClientFilter) (models.ClientBreakdown, error) {
+	query := map[string]interface{}{
+		"size":  0,
+		"query": userIdsQuery(filter),
+		"aggs":  breakdownAggs(),
+	}
+
+	sr, err := doSearch(client, "clients-searcher", query)
+	if err != nil {
+		return models.ClientBreakdown{}, err
+	}
+
+	var aggs breakdownBuckets
+	if err := json.Unmarshal(sr.Aggregations, &aggs); err != nil {
+		return models.ClientBreakdown{}, fmt.Errorf("failed to parse client breakdown: %v", err)
+	}
+	return aggs.breakdown(), nil
+}
+
+// breakdownAggs are the aggregations read back by breakdownBuckets.
+func breakdownAggs() map[string]interface{} {
+	return map[string]interface{}{
+		"countries": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "user.countryId", "size": 500, "missing": 0},
+		},
+		"platforms": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "stats.platform", "size": 100, "missing": 0},
+		},
+		"cohorts": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"size":   1200,
+				"script": map[string]interface{}{"source": cohortScript, "lang": "painless"},
+			},
+		},
+	}
+}
+
+type keyCount struct {
+	Key      interface{} `json:"key"`
+	DocCount int         `json:"doc_count"`
+}
+
+type breakdownBuckets struct {
+	Countries struct {
+		Buckets []keyCount `json:"buckets"`
+	} `json:"countries"`
+	Platforms struct {
+		Buckets []keyCount `json:"buckets"`
+	} `json:"platforms"`
+	Cohorts struct {
+		Buckets []keyCount `json:"buckets"`
+	} `json:"cohorts"`
+}
+
+func (a breakdownBuckets) breakdown() models.ClientBreakdown {
+	bd := models.ClientBreakdown{ByCountry: map[int]int{}, ByPlatform: map[int]int{}, ByCohort: map[string]int{}}
+	for _, b := range a.Countries.Buckets {
+		bd.ByCountry[int(toFloat(b.Key))] += b.DocCount
+		bd.Total += b.DocCount
+	}
+	for _, b := range a.Platforms.Buckets {
+		bd.ByPlatform[int(toFloat(b.Key))] += b.DocCount
+	}
+	for _, b := range a.Cohorts.Buckets {
+		key, _ := b.Key.(string)
+		bd.ByCohort[key] += b.DocCount
+	}
+	return bd
+}
+
+// GetClientJoin counts, for each group of userIds, the users that have a
+// clients-searcher document and breaks down those whose document matches
+// filter, with one filters aggregation over all the groups.
+func GetClientJoin(client *opensearch.Client, groups map[string][]string, filter models.ClientFilter) (map[string]models.ClientJoin, error) {
+	out := make(map[string]models.ClientJoin, len(groups))
+	if len(groups) == 0 {
+		return out, nil
+	}
+
+	var all []int64
+	filters := make(map[string]interface{}, len(groups))
+	for key, userIds := range groups {
+		ids := make([]int64, 0, len(userIds))
+		for _, uid := range userIds {
+			id, err := toInt64(uid)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		all = append(all, ids...)
+		filters[key] = map[string]interface{}{"terms": map[string]interface{}{"stats.userId": ids}}
+	}
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{"stats.userId": all},
+		},
+		"aggs": map[string]interface{}{
+			"groups": map[string]interface{}{
+				"filters": map[string]interface{}{"filters": filters},
+				"aggs": map[string]interface{}{
+					"matching": map[string]interface{}{
+						"filter": userIdsQuery(filter),
+						"aggs":   breakdownAggs(),
+					},
+				},
+			},
+		},
+	}
+
+	sr, err := doSearch(client, "clients-searcher", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var aggs struct {
+		Groups struct {
+			Buckets map[string]struct {
+				DocCount int              `json:"doc_count"`
+				Matching breakdownBuckets `json:"matching"`
+			} `json:"buckets"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal(sr.Aggregations, &aggs); err != nil {
+		return nil, fmt.Errorf("failed to parse client join: %v", err)
+	}
+	for key, b := range aggs.Groups.Buckets {
+		out[key] = models.ClientJoin{Registered: b.DocCount, Matching: b.Matching.breakdown()}
+	}
+	return out, nil
+}
+
+// GetActionMonths returns, for each of userIds, the months ("2006-01", UTC)
+// in which the user has any action within r, in ascending order. Users
+// without actions are absent.
+func GetActionMonths(client *opensearch.Client, userIds []string, r models.ActionRange) (map[string][]string, error) {
+	out := map[string][]string{}
+	if len(userIds) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(userIds))
+	for _, uid := range userIds {
+		id, err := toInt64(uid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	must := []map[string]interface{}{
+		{"terms": map[string]interface{}{"user.id": ids}},
+	}
+	must = append(must, anyRangeClause(r)...)
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": must,
+			},
+		},
+		"aggs": map[string]interface{}{
+			"users": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "user.id", "size": len(ids)},
+				"aggs": map[string]interface{}{
+					"months": map[string]interface{}{
+						"terms": map[string]interface{}{
+							"size": 1200,
+							"script": map[string]interface{}{
+								"source": actionMonthScript,
+								"lang":   "painless",
+								"params": map[string]interface{}{"roots": actionRoots()},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	sr, err := doSearch(client, strings.Join(actionIndices(), ","), query)
+	if err != nil {
+		return nil, err
+	}
+
+	var aggs struct {
+		Users struct {
+			Buckets []struct {
+				Key    interface{} `json:"key"`
+				Months struct {
+					Buckets []struct {
+						Key string `json:"key"`
+					} `json:"buckets"`
+				} `json:"months"`
+			} `json:"buckets"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(sr.Aggregations, &aggs); err != nil {
+		return nil, fmt.Errorf("failed to parse action months aggregation: %v", err)
+	}
+
+	for _, b := range aggs.Users.Buckets {
+		var months []string
+		for _, m := range b.Months.Buckets {
+			if m.Key != "" {
+				months = append(months, m.Key)
+			}
+		}
+		sort.Strings(months)
+		out[formatId(b.Key)] = months
+	}
+	return out, nil
+}
+
+// GetFirstActionTimes returns the createdAt of the earliest action in index
+// of each of userIds, up to until when it is not zero. Users without
+// actions there are absent.
+func GetFirstActionTimes(client *opensearch.Client, userIds []string, index string, until int64) (map[string]int64, error) {
+	out := map[string]int64{}
+	if len(userIds) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(userIds))
+	for _, uid := range userIds {
+		id, err := toInt64(uid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	must := []map[string]interface{}{
+		{"terms": map[string]interface{}{"user.id": ids}},
+	}
+	must = append(must, untilClause(index, until)...)
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": must,
+			},
+		},
+		"aggs": map[string]interface{}{
+			"users": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "user.id", "size": len(ids)},
+				"aggs": map[string]interface{}{
+					"first": map[string]interface{}{
+						"min": map[string]interface{}{"field": constants.Indices[index] + ".createdAt"},
+					},
+				},
+			},
+		},
+	}
+
+	sr, err := doSearch(client, index, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var aggs struct {
+		Users struct {
+			Buckets []struct {
+				Key   interface{} `json:"key"`
+				First struct {
+					Value *float64 `json:"value"`
+				} `json:"first"`
+			} `json:"buckets"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(sr.Aggregations, &aggs); err != nil {
+		return nil, fmt.Errorf("failed to parse first actions aggregation of %s: %v", index, err)
+	}
+
+	for _, b := range aggs.Users.Buckets {
+		if b.First.Value != nil {
+			out[formatId(b.Key)] = int64(*b.First.Value)
+		}
+	}
+	return out, nil
+}
+
+// GetActionStats summarises the actions in index of each of userIds over
+// each of ranges. The slice of a user has one entry per range, in order;
+// users without actions in any of them are absent.
+func GetActionStats(client *opensearch.Client, userIds []string, index string, ranges []models.ActionRange) (map[string][]models.ActionStats, error) {
+	out := map[string][]models.ActionStats{}
+	if len(userIds) == 0 || len(ranges) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(userIds))
+	for _, uid := range userIds {
+		id, err := toInt64(uid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	root := constants.Indices[index]
+	buckets := make([]map[string]interface{}, 0, len(ranges))
+	for i, r := range ranges {
+		b := map[string]interface{}{"key": strconv.Itoa(i)}
+		if r.From != 0 {
+			b["from"] = r.From
+		}
+		if r.To != 0 {
+			// The upper bound of a range aggregation is exclusive.
+			b["to"] = r.To + 1
+		}
+		buckets = append(buckets, b)
+	}
+
+	query := map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{"user.id": ids},
+		},
+		"aggs": map[string]interface{}{
+			"users": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "user.id", "size": len(ids)},
+				"aggs": map[string]interface{}{
+					"ranges": map[string]interface{}{
+						"range": map[string]interface{}{"field": root + ".createdAt", "keyed": true, "ranges": buckets},
+						"aggs": map[string]interface{}{
+							"first": map[string]interface{}{"min": map[string]interface{}{"field": root + ".createdAt"}},
+							"last":  map[string]interface{}{"max": map[string]interface{}{"field": root + ".createdAt"}},
+							"currencies": map[string]interface{}{
+								"terms": map[string]interface{}{"field": root + ".currencyId", "size": 50, "missing": 0},
+								"aggs": map[string]interface{}{
+									"amount": map[string]interface{}{"sum": map[string]interface{}{"field": root + ".amount"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	sr, err := doSearch(client, index, query)
+	if err != nil {
+		return nil, err
+	}
+
+	type value struct {
+		Value *float64 `json:"value"`
+	}
+	var aggs struct {
+		Users struct {
+			Buckets []struct {
+				Key    interface{} `json:"key"`
+				Ranges struct {
+					Buckets map[string]struct {
+						DocCount   int   `json:"doc_count"`
+						First      value `json:"first"`
+						Last       value `json:"last"`
+						Currencies struct {
+							Buckets []struct {
+								Key      interface{} `json:"key"`
+								DocCount int         `json:"doc_count"`
+								Amount   value       `json:"amount"`
+							} `json:"buckets"`
+						} `json:"currencies"`
+					} `json:"buckets"`
+				} `json:"ranges"`
+			} `json:"buckets"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(sr.Aggregations, &aggs); err != nil {
+		return nil, fmt.Errorf("failed to parse action stats aggregation of %s: %v", index, err)
+	}
+
+	for _, u := range aggs.Users.Buckets {
+		stats := make([]models.ActionStats, len(ranges))
+		for i := range ranges {
+			b, ok := u.Ranges.Buckets[strconv.Itoa(i)]
+			if !ok || b.DocCount == 0 {
+				continue
+			}
+			st := models.ActionStats{Count: b.DocCount, Amounts: map[int]float64{}, Counts: map[int]int{}}
+			if b.First.Value != nil {
+				st.First = int64(*b.First.Value)
+			}
+			if b.Last.Value != nil {
+				st.Last = int64(*b.Last.Value)
+			}
+			for _, cur := range b.Currencies.Buckets {
+				currencyId := int(toFloat(cur.Key))
+				st.Counts[currencyId] += cur.DocCount
+				if cur.Amount.Value != nil {
+					st.Amounts[currencyId] += *cur.Amount.Value
+				}
+			}
+			stats[i] = st
+		}
+		out[formatId(u.Key)] = stats
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"action_users/models"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// ClientStore reads registered clients from clients-searcher.
+type ClientStore interface {
+	GetUserIds(from, size int, filter models.ClientFilter) ([]string, error)
+	GetUserIdsAfter(cursor models.Cursor, size int, filter models.ClientFilter) ([]string, *models.Cursor, error)
+	GetClientById(userIdStr string, countries models.Countries) (map[string]interface{}, error)
+	// ReleaseCursor frees a cursor that will not be walked to the end.
+	ReleaseCursor(cursor models.Cursor) error
+}
+
+// ActivityStore reads user actions from the indices in constants.Indices.
+// Actions created after until are ignored unless until is 0.
+type ActivityStore interface {
+	GetActionsFromIndex(userIdStr string, index string, size int, countries models.Countries, until int64) ([]models.Action, error)
+	GetActionsFromIndexNoCountry(userIdStr string, index string, size int, until int64) ([]models.Action, error)
+	GetActionsInRange(userIdStr string, index string, size int, r models.ActionRange) ([]models.Action, error)
+	GetLastActionsForUsers(userIds []string, indicesList []string, size int, countries models.Countries, until int64) (map[string]map[string][]models.Action, error)
+}
+
+// StatsStore answers the aggregate questions behind the statistics
+// endpoints without reading users one by one.
+type StatsStore interface {
+	GetLastActionsAfter(cursor models.Cursor, size int, until int64) ([]models.LastAction, *models.Cursor, error)
+	GetClientsByIds(userIds []string) (map[string]map[string]interface{}, error)
+	GetClientBreakdown(filter models.ClientFilter) (models.ClientBreakdown, error)
+	GetClientJoin(groups map[string][]string, filter models.ClientFilter) (map[string]models.ClientJoin, error)
+	GetActionMonths(userIds []string, r models.ActionRange) (map[string][]string, error)
+	GetFirstActionTimes(userIds []string, index string, until int64) (map[string]int64, error)
+	GetActionStats(userIds []string, index string, ranges []models.ActionRange) (map[string][]models.ActionStats, error)
+}
+
+// SegmentStore keeps segmentation results for other consumers.
+type SegmentStore interface {
+	SaveSegments(index string, docs []models.SegmentDocument) error
+}
+
+type Store interface {
+	ClientStore
+	ActivityStore
+	StatsStore
+	SegmentStore
+}
+
+// OpenSearchStore is the Store backed by a live cluster.
+type OpenSearchStore struct {
+	client *opensearch.Client
+}
+
+func NewOpenSearchStore(client *opensearch.Client) *OpenSearchStore {
+	return &OpenSearchStore{client: client}
+}
+
+func (s *OpenSearchStore) GetUserIds(from, size int, filter models.ClientFilter) ([]string, error) {
+	return GetUserIds(s.client, from, size, filter)
+}
+
+func (s *OpenSearchStore) GetUserIdsAfter(cursor models.Cursor, size int, filter models.ClientFilter) ([]string, *models.Cursor, error) {
+	return GetUserIdsAfter(s.client, cursor, size, filter)
+}
+
+func (s *OpenSearchStore) ReleaseCursor(cursor models.Cursor) error {
+	return ClosePointInTime(s.client, cursor.PitId)
+}
+
+func (s *OpenSearchStore) GetClientById(userIdStr string, countries models.Countries) (map[string]interface{}, error) {
+	return GetClientById(s.client, userIdStr, countries)
+}
+
+func (s *OpenSearchStore) GetActionsFromIndex(userIdStr string, index string, size int, countries models.Countries, until int64) ([]models.Action, error) {
+	return GetActionsFromIndex(s.client, userIdStr, index, size, countries, until)
+}
+
+func (s *OpenSearchStore) GetActionsFromIndexNoCountry(userIdStr string, index string, size int, until int64) ([]models.Action, error) {
+	return GetActionsFromIndexNoCountry(s.client, userIdStr, index, size, until)
+}
+
+func (s *OpenSearchStore) GetActionsInRange(userIdStr string, index string, size int, r models.ActionRange) ([]models.Action, error) {
+	return GetActionsInRange(s.client, userIdStr, index, size, r)
+}
+
+func (s *OpenSearchStore) GetLastActionsForUsers(userIds []string, indicesList []string, size int, countries models.Countries, until int64) (map[string]map[string][]models.Action, error) {
+	return GetLastActionsForUsers(s.client, userIds, indicesList, size, countries, until)
+}
+
+func (s *OpenSearchStore) GetLastActionsAfter(cursor models.Cursor, size int, until int64) ([]models.LastAction, *models.Cursor, error) {
+	return GetLastActionsAfter(s.client, cursor, size, until)
+}
+
+func (s *OpenSearchStore) GetClientsByIds(userIds []string) (map[string]map[string]interface{}, error) {
+	return GetClientsByIds(s.client, userIds)
+}
+
+func (s *OpenSearchStore) GetClientBreakdown(filter models.ClientFilter) (models.ClientBreakdown, error) {
+	return GetClientBreakdown(s.client, filter)
+}
+
+func (s *OpenSearchStore) GetClientJoin(groups map[string][]string, filter models.ClientFilter) (map[string]models.ClientJoin, error) {
+	return GetClientJoin(s.client, groups, filter)
+}
+
+func (s *OpenSearchStore) GetActionMonths(userIds []string, r models.ActionRange) (map[string][]string, error) {
+	return GetActionMonths(s.client, userIds, r)
+}
+
+func (s *OpenSearchStore) GetFirstActionTimes(userIds []string, index string, until int64) (map[string]int64, error) {
+	return GetFirstActionTimes(s.client, userIds, index, until)
+}
+
+func (s *OpenSearchStore) GetActionStats(userIds []string, index string, ranges []models.ActionRange) (map[string][]models.ActionStats, error) {
+	return GetActionStats(s.client, userIds, index, ranges)
+}
+
+func (s *OpenSearchStore) SaveSegments(index string, docs []models.SegmentDocument) error {
+	return IndexSegments(s.client, index, docs)
+}
@@ -0,0 +1,98 @@
+// Package rfm scores users by Recency, Frequency and Monetary value and
+// names the segment each combination of scores falls in.
+package rfm
+
+import "sort"
+
+// Segment names, from the most to the least engaged.
+const (
+	Champions          = "Champions"
+	LoyalCustomers     = "Loyal Customers"
+	PotentialLoyalists = "Potential Loyalists"
+	NewCustomers       = "New Customers"
+	Promising          = "Promising"
+	NeedAttention      = "Need Attention"
+	AboutToSleep       = "About to Sleep"
+	CantLoseThem       = "Can't Lose Them"
+	AtRisk             = "At Risk"
+	Hibernating        = "Hibernating"
+	Lost               = "Lost"
+)
+
+// Segments lists every segment in the order above.
+var Segments = []string{
+	Champions, LoyalCustomers, PotentialLoyalists, NewCustomers, Promising,
+	NeedAttention, AboutToSleep, CantLoseThem, AtRisk, Hibernating, Lost,
+}
+
+// Scores are the R, F and M quintiles of a user, each from 1 to 5 with 5 the
+// best: the most recent, most frequent or highest spending fifth.
+type Scores struct {
+	R int `json:"r"`
+	F int `json:"f"`
+	M int `json:"m"`
+}
+
+// Quintiles scores values from 1 to 5 by rank, the highest values getting 5.
+// Equal values always get the same score, that of the first of them in rank
+// order, so a population where most values are zero scores them all 1.
+func Quintiles(values []float64) []int {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return values[order[a]] < values[order[b]]
+	})
+
+	scores := make([]int, len(values))
+	first := 0
+	for rank, i := range order {
+		if rank > 0 && values[i] != values[order[rank-1]] {
+			first = rank
+		}
+		scores[i] = 1 + 5*first/len(values)
+	}
+	return scores
+}
+
+// Segment names the segment of s. Recency is crossed with the rounded mean
+// of the frequency and monetary scores.
+func Segment(s Scores) string {
+	fm := (s.F + s.M + 1) / 2
+	switch s.R {
+	case 5:
+		switch {
+		case fm >= 4:
+			return Champions
+		case fm >= 2:
+			return PotentialLoyalists
+		}
+		return NewCustomers
+	case 4:
+		switch {
+		case fm >= 4:
+			return LoyalCustomers
+		case fm >= 2:
+			return PotentialLoyalists
+		}
+		return Promising
+	case 3:
+		switch {
+		case fm >= 4:
+			return LoyalCustomers
+		case fm == 3:
+			return NeedAttention
+		}
+		return AboutToSleep
+	}
+	switch {
+	case fm == 5:
+		return CantLoseThem
+	case fm >= 3:
+		return AtRisk
+	case s.R == 2:
+		return Hibernating
+	}
+	return Lost
+}
@@ -0,0 +1,103 @@
+package rfm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestQuintiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []int
+	}{
+		{name: "empty", values: nil, want: []int{}},
+		{name: "single user", values: []float64{42}, want: []int{1}},
+		{name: "all zero", values: []float64{0, 0, 0, 0, 0, 0}, want: []int{1, 1, 1, 1, 1, 1}},
+		{
+			name:   "ten distinct values",
+			values: []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+			want:   []int{5, 5, 4, 4, 3, 3, 2, 2, 1, 1},
+		},
+		{
+			name:   "five values in any order",
+			values: []float64{30, -5, 100, 0, 7},
+			want:   []int{4, 1, 5, 2, 3},
+		},
+		{
+			// The tied values take the score of the lowest of them.
+			name:   "tie across a boundary",
+			values: []float64{1, 2, 3, 3, 3, 3, 4, 5, 6, 7},
+			want:   []int{1, 1, 2, 2, 2, 2, 4, 4, 5, 5},
+		},
+		{
+			name:   "mostly zero",
+			values: []float64{0, 0, 0, 0, 0, 0, 0, 0, 50, 20},
+			want:   []int{1, 1, 1, 1, 1, 1, 1, 1, 5, 5},
+		},
+		{
+			name:   "negative values",
+			values: []float64{-1, -30, -2, -30, -400},
+			want:   []int{5, 2, 4, 2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Quintiles(tt.values); !slices.Equal(got, tt.want) {
+				t.Errorf("Quintiles(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegment(t *testing.T) {
+	// Columns are the rounded mean of F and M from 1 to 5.
+	matrix := map[int][5]string{
+		5: {NewCustomers, PotentialLoyalists, PotentialLoyalists, Champions, Champions},
+		4: {Promising, PotentialLoyalists, PotentialLoyalists, LoyalCustomers, LoyalCustomers},
+		3: {AboutToSleep, AboutToSleep, NeedAttention, LoyalCustomers, LoyalCustomers},
+		2: {Hibernating, Hibernating, AtRisk, AtRisk, CantLoseThem},
+		1: {Lost, Lost, AtRisk, AtRisk, CantLoseThem},
+	}
+	// Representative F and M for each column, including uneven pairs that
+	// round up.
+	pairs := [5][][2]int{
+		{{1, 1}},
+		{{2, 2}, {1, 2}, {1, 3}},
+		{{3, 3}, {2, 3}, {1, 5}},
+		{{4, 4}, {3, 4}, {5, 2}},
+		{{5, 5}, {4, 5}},
+	}
+
+	for r, row := range matrix {
+		for col, want := range row {
+			for _, fm := range pairs[col] {
+				s := Scores{R: r, F: fm[0], M: fm[1]}
+				if got := Segment(s); got != want {
+					t.Errorf("Segment(%+v) = %s, want %s", s, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSegmentCoversSegments(t *testing.T) {
+	seen := map[string]bool{}
+	for r := 1; r <= 5; r++ {
+		for f := 1; f <= 5; f++ {
+			for m := 1; m <= 5; m++ {
+				s := Segment(Scores{R: r, F: f, M: m})
+				if !slices.Contains(Segments, s) {
+					t.Errorf("Segment(%d, %d, %d) = %q, not in Segments", r, f, m, s)
+				}
+				seen[s] = true
+			}
+		}
+	}
+	for _, s := range Segments {
+		if !seen[s] {
+			t.Errorf("segment %s is never assigned", s)
+		}
+	}
+}
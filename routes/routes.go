@@ -9,24 +9,196 @@ import (
 func SetupRoutes(app *fiber.App, handler *handlers.Handler) {
 	app.Get("/health", handler.HealthCheck)
 
+	app.Get("/rules", handler.ListRules)
+	app.Get("/countries", handler.ListCountries)
+	app.Get("/currencies", handler.ListCurrencies)
+
 	app.Get("/process-users", handler.ProcessUsers)
+	app.Get("/process-users/export", handler.ExportUsers)
+	app.Post("/process-users/batch", handler.ProcessUsersBatch)
+
+	app.Get("/users/:userId/timeline", handler.UserTimeline)
+	app.Get("/users/:userId/status", handler.UserStatus)
+
+	app.Get("/stats/inactivity", handler.InactivityStats)
+	app.Get("/stats/retention", handler.RetentionMatrix)
+	app.Get("/stats/funnel", handler.Funnel)
+
+	app.Get("/segments/rfm", handler.RFMSegments)
+
+	app.Post("/jobs/process-users", handler.CreateProcessUsersJob)
+	app.Get("/jobs/:id", handler.GetJob)
+	app.Get("/jobs/:id/result", handler.GetJobResult)
+	app.Delete("/jobs/:id", handler.CancelJob)
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"message": "User Actions API",
 			"endpoints": fiber.Map{
-				"health": "/health",
+				"health":     "/health",
+				"rules":      "/rules",
+				"countries":  "/countries - справочник стран (id, name, currencyId, currencyCode) и группы стран",
+				"currencies": "/currencies - справочник валют (id, ISO-код, курс к reportingCurrency) и страны с этой валютой отчётности",
 				"process-users": fiber.Map{
 					"method": "GET",
 					"path":   "/process-users",
 					"parameters": fiber.Map{
-						"months":    "Количество месяцев для определения неактивности И вычисления порога реактивации (default: 1)",
-						"countryId": "ID страны (default: 0 - все страны)",
-						"page":      "Номер страницы (default: 1)",
-						"limit":     "Количество записей на странице (default: 100, max: 1000)",
+						"months":           "Количество месяцев для определения неактивности И вычисления порога реактивации (default: 1)",
+						"countryId":        "ID стран и/или имена групп из COUNTRY_GROUPS_FILE через запятую, например 213,233 или central-asia (default: 0 - все страны)",
+						"excludeCountryId": "Исключить страны, формат как у countryId",
+						"page":             "Номер страницы (default: 1)",
+						"limit":            "Количество записей на странице (default: 100, max: 1000)",
+						"pagination":       "cursor - постраничный обход через point-in-time без ограничения max_result_window",
+						"cursor":           "Токен nextCursor из предыдущего ответа (page игнорируется); передавать с теми же months, countryId, фильтрами клиентов, rule, mode и asOf, иначе 400",
+						"persist":          "true - сохранить результат в индекс user-activity-segments-YYYY.MM.DD (дата asOf, если задан; userId = _id)",
+						"rule":             "Имя правила неактивности из RULES_FILE (default: months как разрыв между двумя последними действиями в календарных месяцах), список - GET /rules",
+						"mode":             "Для правила default: gap - разрыв между двумя последними действиями, since-last - с последнего действия до asOf, both - любое из двух (default: gap)",
+						"asOf":             "Расчёт на прошлую дату: клиенты, зарегистрированные позже, и действия после неё игнорируются; unix-секунды, YYYY-MM-DD или RFC 3339 (default: текущее время)",
+						"createdFrom":      "Только клиенты, зарегистрированные не раньше даты (user.createdAt); формат как у asOf",
+						"createdTo":        "Только клиенты, зарегистрированные не позже даты; YYYY-MM-DD включает весь день",
+						"platform":         "Платформы через запятую (stats.platform), например 2,3",
+						"state":            "Состояния клиента через запятую (user.state)",
+						"balanceFrom":      "Минимальный баланс кошелька включительно (хотя бы один кошелёк в диапазоне)",
+						"balanceTo":        "Максимальный баланс кошелька включительно",
+						"monetary":         "true - добавить churnScore/riskBand и monetary каждому пользователю с действиями (ещё одна агрегация по индексам действий на страницу, как и при minRisk)",
+						"window":           "Окно для monetary.window при monetary=true: число и d, w или m, например 30d, 6m (default: 3m)",
+						"minRisk":          "Минимальный churnScore (0..1) или полоса low/medium/high: активные пользователи со score не ниже порога попадают в atRiskUsers; inactiveUsers, orphanUsers и registeredNoActions порогом не фильтруются",
 					},
-					"example": "/process-users?months=3&countryId=213&page=1&limit=50",
-					"logic":   "Для каждого неактивного пользователя: lastActivity - months = reactivationThreshold",
+					"example":        "/process-users?months=3&countryId=213&page=1&limit=50",
+					"cursor_example": "/process-users?months=3&countryId=213&limit=1000&pagination=cursor",
+					"filter_example": "/process-users?platform=2&createdFrom=2025-01-01&createdTo=2025-12-31&balanceFrom=0.01",
+					"logic":          "Для каждого неактивного пользователя: lastActivity - months = reactivationThreshold; при monetary=true или minRisk churnScore/riskBand - вероятность ухода по давности, частоте действий и ставок за 90 дней, тренду пополнений и разрывов между действиями; monetary - суммы пополнений, ставок и выводов, netRevenue (пополнения - выводы) и averageBet (по ставкам в валютах с курсом) за всё время (lifetime) и за window в валюте reportingCurrency из REFERENCE_FILE (валюты без курса - в unconverted); Account.wallets - все кошельки клиента (active - первый с isActive = 1), totalBalance - сумма балансов в reportingCurrency",
+					"risk_example":   "/process-users?months=3&countryId=213&minRisk=high",
+				},
+				"process-users/export": fiber.Map{
+					"method": "GET",
+					"path":   "/process-users/export",
+					"parameters": fiber.Map{
+						"months":           "Как в /process-users (default: 1)",
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"segment":          "inactive | orphan | registeredNoActions | atRisk | all (default: inactive)",
+						"format":           "ndjson | csv (default: по заголовку Accept, иначе ndjson)",
+						"limit":            "Размер внутренней страницы обхода (default: 1000, max: 1000)",
+						"persist":          "Как в /process-users",
+						"rule":             "Как в /process-users",
+						"mode":             "Как в /process-users",
+						"asOf":             "Как в /process-users",
+						"filters":          "createdFrom, createdTo, platform, state, balanceFrom, balanceTo, minRisk, monetary, window - как в /process-users",
+					},
+					"example": "/process-users/export?months=3&countryId=213&format=csv",
+				},
+				"process-users/batch": fiber.Map{
+					"method": "POST",
+					"path":   "/process-users/batch",
+					"body":   "JSON-массив userId ([1001, \"1002\"]) или по одному userId на строку; файл можно загрузить как multipart-поле file (max 5000, больше - через POST /jobs/process-users)",
+					"parameters": fiber.Map{
+						"months":           "Как в /process-users (default: 1)",
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"persist":          "Как в /process-users",
+						"rule":             "Как в /process-users",
+						"mode":             "Как в /process-users",
+						"asOf":             "Как в /process-users",
+						"minRisk":          "Как в /process-users",
+						"monetary":         "Как в /process-users",
+						"window":           "Как в /process-users",
+					},
+					"example": "curl -X POST --data-binary @user_ids.txt '/process-users/batch?months=3&countryId=213'",
+					"logic":   "Без обхода clients-searcher: классификация только переданных userId, ответ как у /process-users",
+				},
+				"users/{userId}/timeline": fiber.Map{
+					"method": "GET",
+					"path":   "/users/{userId}/timeline",
+					"parameters": fiber.Map{
+						"type":  "Типы действий через запятую: TOP_UP, BET, WITHDRAWAL, CASHIER_CARD, TERMINAL_TRANSACTION (default: все)",
+						"from":  "Начало периода: unix-секунды, YYYY-MM-DD или RFC 3339",
+						"to":    "Конец периода включительно, формат как у from",
+						"page":  "Номер страницы (default: 1)",
+						"limit": "Количество действий на странице (default: 50, max: 1000, page*limit <= 10000)",
+					},
+					"example": "/users/1001/timeline?type=TOP_UP,BET&from=2024-01-01&limit=20",
+					"logic":   "Действия из всех индексов в одной ленте, от новых к старым",
+				},
+				"users/{userId}/status": fiber.Map{
+					"method": "GET",
+					"path":   "/users/{userId}/status",
+					"parameters": fiber.Map{
+						"months":           "Как в /process-users (default: 1)",
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"rule":             "Как в /process-users",
+						"mode":             "Как в /process-users",
+						"asOf":             "Как в /process-users",
+					},
+					"example": "/users/1001/status?months=3&countryId=213",
+					"logic":   "Одна итерация /process-users для пользователя: clientData (segment: inactive | orphan | registeredNoActions | active, churnScore, riskBand) и trace - найденные действия по индексам, lookup country/noCountry (fallback без страны), условия правила, признаки churn",
+				},
+				"stats/inactivity": fiber.Map{
+					"method": "GET",
+					"path":   "/stats/inactivity",
+					"parameters": fiber.Map{
+						"months":           "Как в /process-users (default: 1)",
+						"mode":             "Как в /process-users: правило default с gap, since-last или both (default: gap)",
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"asOf":             "Как в /process-users",
+					},
+					"example": "/stats/inactivity?months=3&mode=since-last&countryId=central-asia",
+					"logic":   "Агрегации OpenSearch по индексам действий и clients-searcher без обхода клиентов: totals и разбивки byCountry, byPlatform, byCohort (месяц регистрации), byLastActionType (NONE - зарегистрированы без действий); зарегистрированные клиенты учитываются по стране из clients-searcher, orphan - по стране последнего действия, у orphan платформа и когорта unknown",
+				},
+				"stats/retention": fiber.Map{
+					"method": "GET",
+					"path":   "/stats/retention",
+					"parameters": fiber.Map{
+						"months":           "Когорты - месяцы регистрации за последние months месяцев до asOf, столбцы - месяцы 0..months после регистрации (default: 12, max: 120)",
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"asOf":             "Как в /process-users",
+						"format":           "json или csv (default: json)",
+					},
+					"example": "/stats/retention?months=6&countryId=213&format=csv",
+					"logic":   "Для каждой когорты (user.createdAt, UTC) доля клиентов с любым действием в месяце k после регистрации; последний столбец каждой когорты - текущий месяц asOf (partialMonth), посчитанный только до asOf; ещё не наступившие месяцы не выводятся",
+				},
+				"stats/funnel": fiber.Map{
+					"method": "GET",
+					"path":   "/stats/funnel",
+					"parameters": fiber.Map{
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"asOf":             "Как в /process-users",
+						"createdFrom":      "Как в /process-users",
+						"createdTo":        "Как в /process-users",
+						"platform":         "Как в /process-users",
+						"state":            "Как в /process-users",
+						"balanceFrom":      "Как в /process-users",
+						"balanceTo":        "Как в /process-users",
+					},
+					"example": "/stats/funnel?countryId=213&createdFrom=2025-01-01",
+					"logic":   "Воронка registered -> firstTopUp -> firstBet -> firstWithdrawal (по порядку шагов) в целом, byCountry и byPlatform: users, share, conversion, timeToStep - дни от регистрации до первого действия шага, stalled/stalledFor - остановились на шаге и сколько дней назад его достигли (p25/median/p75/p90 и корзины)",
+				},
+				"segments/rfm": fiber.Map{
+					"method": "GET",
+					"path":   "/segments/rfm",
+					"parameters": fiber.Map{
+						"window":           "Окно для frequency и monetary: число и d, w или m, например 90d, 12w, 6m (default: 12m)",
+						"countryId":        "Как в /process-users (default: 0 - все страны)",
+						"excludeCountryId": "Как в /process-users",
+						"asOf":             "Как в /process-users",
+						"filters":          "createdFrom, createdTo, platform, state, balanceFrom, balanceTo - как в /process-users",
+						"segment":          "Только пользователи сегмента: Champions, Loyal Customers, Potential Loyalists, New Customers, Promising, Need Attention, About to Sleep, Can't Lose Them, At Risk, Hibernating, Lost",
+						"page":             "Номер страницы users (default: 1)",
+						"limit":            "Размер страницы users (default: 100, max: 1000)",
+					},
+					"example": "/segments/rfm?window=6m&countryId=213&segment=At%20Risk",
+					"logic":   "Обход всех клиентов как в /process-users: recency - дней с последнего действия, frequency - число действий за окно, monetary - сумма пополнений за окно в отчётной валюте (summary.currency; пополнения в валютах без курса - в unconverted), без отчётной валюты - в своей валюте и квинтиль M внутри страны; квинтили 1..5 по всей выборке, сегмент по R и среднему F и M; клиенты без действий - только в noActions. Результат для countryId, window, asOf и фильтров считается один раз и 15 минут отдаётся из памяти (summary.computedAt), страницы и segment режутся из него",
+				},
+				"jobs": fiber.Map{
+					"create":    "POST /jobs/process-users?months=3&countryId=213&persist=true - фоновый обход всех клиентов, параметры как в /process-users",
+					"status":    "GET /jobs/{id} - статус и прогресс (usersScanned, inactive/orphan/registeredNoActions)",
+					"result":    "GET /jobs/{id}/result?format=json|ndjson|csv&segment= - результат (частичный для отменённых задач); хранится только у 5 последних завершённых задач, у более старых resultDropped=true и 410",
+					"retention": "Завершённые задачи удаляются через 24 часа",
+					"cancel":    "DELETE /jobs/{id} - отмена; задача и частичный результат сохраняются",
 				},
 			},
 		})
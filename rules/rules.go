@@ -0,0 +1,398 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"action_users/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Unit string
+
+const (
+	Days   Unit = "days"
+	Weeks  Unit = "weeks"
+	Months Unit = "months"
+)
+
+// Window is a span of time counted back from a moment. Months are calendar
+// months, so one month back from March 31 is February 28 or 29.
+type Window struct {
+	Value int  `json:"value" yaml:"value"`
+	Unit  Unit `json:"unit" yaml:"unit"`
+}
+
+// Before returns t moved back by the window.
+func (w Window) Before(t time.Time) time.Time {
+	switch w.Unit {
+	case Weeks:
+		return t.AddDate(0, 0, -7*w.Value)
+	case Months:
+		return MonthsBefore(t, w.Value)
+	default:
+		return t.AddDate(0, 0, -w.Value)
+	}
+}
+
+// MonthsBefore moves t back by whole calendar months, clamping the day to
+// the end of the target month where time.AddDate would roll over into the
+// next one.
+func MonthsBefore(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month-time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func (w Window) validate() error {
+	if w.Value < 0 {
+		return fmt.Errorf("negative window %d", w.Value)
+	}
+	switch w.Unit {
+	case Days, Weeks, Months:
+		return nil
+	}
+	return fmt.Errorf("unknown window unit %q (days, weeks, months)", w.Unit)
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%d %s", w.Value, w.Unit)
+}
+
+// ParseWindow reads a window written as a positive count followed by d, w
+// or m, such as 90d or 12m.
+func ParseWindow(s string) (Window, error) {
+	units := map[string]Unit{"d": Days, "w": Weeks, "m": Months}
+	if len(s) < 2 {
+		return Window{}, fmt.Errorf("invalid window %q (e.g. 90d, 12w, 6m)", s)
+	}
+	unit, ok := units[strings.ToLower(s[len(s)-1:])]
+	value, err := strconv.Atoi(s[:len(s)-1])
+	if !ok || err != nil || value < 1 {
+		return Window{}, fmt.Errorf("invalid window %q (e.g. 90d, 12w, 6m)", s)
+	}
+	return Window{Value: value, Unit: unit}, nil
+}
+
+// Scope selects which actions a condition looks at.
+type Scope string
+
+const (
+	AnyAction  Scope = "any"
+	TopUp      Scope = "topUp"
+	Bet        Scope = "bet"
+	Withdrawal Scope = "withdrawal"
+)
+
+// Condition is one check against a user's latest actions. Exactly one of
+// InactiveFor, ActiveWithin and GapAtLeast is set:
+//   - InactiveFor holds when no action of the scope happened within the
+//     window before now;
+//   - ActiveWithin holds when one did;
+//   - GapAtLeast holds when the two most recent actions are at least the
+//     window apart, or the only action is that far from now. It needs the
+//     two latest actions and so only supports the "any" scope.
+type Condition struct {
+	Name         string  `json:"name,omitempty" yaml:"name,omitempty"`
+	Action       Scope   `json:"action" yaml:"action"`
+	InactiveFor  *Window `json:"inactiveFor,omitempty" yaml:"inactiveFor,omitempty"`
+	ActiveWithin *Window `json:"activeWithin,omitempty" yaml:"activeWithin,omitempty"`
+	GapAtLeast   *Window `json:"gapAtLeast,omitempty" yaml:"gapAtLeast,omitempty"`
+}
+
+func (c Condition) validate() error {
+	switch c.Action {
+	case AnyAction, TopUp, Bet, Withdrawal:
+	default:
+		return fmt.Errorf("unknown action %q (any, topUp, bet, withdrawal)", c.Action)
+	}
+
+	var windows []*Window
+	for _, w := range []*Window{c.InactiveFor, c.ActiveWithin, c.GapAtLeast} {
+		if w != nil {
+			windows = append(windows, w)
+		}
+	}
+	if len(windows) != 1 {
+		return fmt.Errorf("exactly one of inactiveFor, activeWithin, gapAtLeast is required")
+	}
+	if c.GapAtLeast != nil && c.Action != AnyAction {
+		return fmt.Errorf("gapAtLeast only supports action %q", AnyAction)
+	}
+	return windows[0].validate()
+}
+
+// Label names the condition in classification reports: its Name, or a
+// description built from its window.
+func (c Condition) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	switch {
+	case c.InactiveFor != nil:
+		return fmt.Sprintf("no %s action within %s", c.Action, c.InactiveFor)
+	case c.ActiveWithin != nil:
+		return fmt.Sprintf("%s action within %s", c.Action, c.ActiveWithin)
+	case c.GapAtLeast != nil:
+		return fmt.Sprintf("gap of at least %s", c.GapAtLeast)
+	}
+	return string(c.Action)
+}
+
+func latest(ua *models.UserActions, scope Scope) int64 {
+	var a *models.Action
+	switch scope {
+	case TopUp:
+		a = ua.TopUp
+	case Bet:
+		a = ua.Bet
+	case Withdrawal:
+		a = ua.Withdrawal
+	default:
+		if len(ua.Recent) > 0 {
+			a = &ua.Recent[0]
+		}
+	}
+	if a == nil {
+		return 0
+	}
+	return a.CreatedAt
+}
+
+func (c Condition) holds(ua *models.UserActions, now time.Time) bool {
+	switch {
+	case c.InactiveFor != nil:
+		last := latest(ua, c.Action)
+		return last == 0 || time.Unix(last, 0).Before(c.InactiveFor.Before(now))
+	case c.ActiveWithin != nil:
+		last := latest(ua, c.Action)
+		return last != 0 && !time.Unix(last, 0).Before(c.ActiveWithin.Before(now))
+	case c.GapAtLeast != nil:
+		return gapAtLeast(ua.Recent, *c.GapAtLeast, now)
+	}
+	return false
+}
+
+func gapAtLeast(recent []models.Action, w Window, now time.Time) bool {
+	if len(recent) == 0 || recent[0].CreatedAt == 0 {
+		return false
+	}
+
+	later := time.Unix(recent[0].CreatedAt, 0)
+	earlier := now
+	if len(recent) > 1 && recent[1].CreatedAt != 0 {
+		earlier = time.Unix(recent[1].CreatedAt, 0)
+	}
+	if later.Before(earlier) {
+		later, earlier = earlier, later
+	}
+	return !w.Before(later).Before(earlier)
+}
+
+// Match says how a rule combines its conditions.
+type Match string
+
+const (
+	MatchAll Match = "all"
+	MatchAny Match = "any"
+)
+
+// Rule classifies a user as inactive when all of its conditions hold, or any
+// of them when Match is "any".
+type Rule struct {
+	Name        string      `json:"name" yaml:"name"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Match       Match       `json:"match,omitempty" yaml:"match,omitempty"`
+	Conditions  []Condition `json:"conditions" yaml:"conditions"`
+}
+
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without name")
+	}
+	if len(r.Conditions) == 0 {
+		return fmt.Errorf("rule %s: no conditions", r.Name)
+	}
+	switch r.Match {
+	case "", MatchAll, MatchAny:
+	default:
+		return fmt.Errorf("rule %s: unknown match %q (all, any)", r.Name, r.Match)
+	}
+	for i, c := range r.Conditions {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("rule %s, condition %d: %v", r.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the user with actions ua is inactive under r at
+// now. Users without any action never match.
+func (r Rule) Matches(ua *models.UserActions, now time.Time) bool {
+	matched, _ := r.Evaluate(ua, now)
+	return matched
+}
+
+// Evaluate is Matches that also returns the labels of the conditions that
+// held, which for a matching rule are the reasons the user is inactive.
+func (r Rule) Evaluate(ua *models.UserActions, now time.Time) (bool, []string) {
+	if ua == nil || len(ua.Recent) == 0 {
+		return false, nil
+	}
+
+	var held []string
+	for _, c := range r.Conditions {
+		if c.holds(ua, now) {
+			held = append(held, c.Label())
+		} else if r.Match != MatchAny {
+			return false, held
+		}
+	}
+	return len(held) > 0, held
+}
+
+// Outcome is whether one condition of a rule held for a user.
+type Outcome struct {
+	Condition string `json:"condition"`
+	Held      bool   `json:"held"`
+}
+
+// Explain evaluates every condition of the rule, without stopping at the
+// first one that decides the match, for showing why a user was classified.
+func (r Rule) Explain(ua *models.UserActions, now time.Time) []Outcome {
+	out := make([]Outcome, 0, len(r.Conditions))
+	for _, c := range r.Conditions {
+		held := ua != nil && len(ua.Recent) > 0 && c.holds(ua, now)
+		out = append(out, Outcome{Condition: c.Label(), Held: held})
+	}
+	return out
+}
+
+// DefaultName is the rule used when none is selected: the months query
+// parameter applied as selected by Mode.
+const DefaultName = "default"
+
+// Mode selects what the default rule measures.
+type Mode string
+
+const (
+	// ModeGap compares the two latest actions with each other.
+	ModeGap Mode = "gap"
+	// ModeSinceLast compares the latest action with now.
+	ModeSinceLast Mode = "since-last"
+	// ModeBoth flags users that either check flags.
+	ModeBoth Mode = "both"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case ModeGap, ModeSinceLast, ModeBoth:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown mode %q (since-last, gap, both)", s)
+}
+
+func Default(months int, mode Mode) Rule {
+	gap := Condition{
+		Name:       string(ModeGap),
+		Action:     AnyAction,
+		GapAtLeast: &Window{Value: months, Unit: Months},
+	}
+	sinceLast := Condition{
+		Name:        string(ModeSinceLast),
+		Action:      AnyAction,
+		InactiveFor: &Window{Value: months, Unit: Months},
+	}
+
+	switch mode {
+	case ModeSinceLast:
+		return Rule{
+			Name:        DefaultName,
+			Description: fmt.Sprintf("no action in the last %d months", months),
+			Conditions:  []Condition{sinceLast},
+		}
+	case ModeBoth:
+		return Rule{
+			Name:        DefaultName,
+			Description: fmt.Sprintf("two latest actions at least %d months apart or no action in the last %d months", months, months),
+			Match:       MatchAny,
+			Conditions:  []Condition{gap, sinceLast},
+		}
+	}
+	return Rule{
+		Name:        DefaultName,
+		Description: fmt.Sprintf("two latest actions at least %d months apart", months),
+		Conditions:  []Condition{gap},
+	}
+}
+
+// Set holds the named rules loaded from the rules file.
+type Set struct {
+	rules map[string]Rule
+}
+
+type file struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+func NewSet(rules ...Rule) (*Set, error) {
+	s := &Set{rules: map[string]Rule{}}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		if r.Name == DefaultName {
+			return nil, fmt.Errorf("rule name %q is reserved", DefaultName)
+		}
+		if _, ok := s.rules[r.Name]; ok {
+			return nil, fmt.Errorf("duplicate rule %s", r.Name)
+		}
+		s.rules[r.Name] = r
+	}
+	return s, nil
+}
+
+// Load reads rules from a YAML (.yaml, .yml) or JSON file.
+func Load(path string) (*Set, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	var f file
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &f)
+	default:
+		err = json.Unmarshal(raw, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %v", path, err)
+	}
+	return NewSet(f.Rules...)
+}
+
+func (s *Set) Get(name string) (Rule, bool) {
+	r, ok := s.rules[name]
+	return r, ok
+}
+
+// List returns the rules sorted by name.
+func (s *Set) List() []Rule {
+	out := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
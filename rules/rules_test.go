@@ -0,0 +1,317 @@
+package rules
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"action_users/models"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 12, 0, 0, 0, time.UTC)
+}
+
+func actions(times ...time.Time) *models.UserActions {
+	ua := &models.UserActions{}
+	for _, t := range times {
+		ua.Recent = append(ua.Recent, models.Action{CreatedAt: t.Unix()})
+	}
+	return ua
+}
+
+func TestWindowBefore(t *testing.T) {
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		want   time.Time
+	}{
+		{"days", Window{Value: 90, Unit: Days}, day(2024, time.March, 31), day(2024, time.January, 1)},
+		{"weeks", Window{Value: 2, Unit: Weeks}, day(2024, time.March, 10), day(2024, time.February, 25)},
+		{"month from mid month", Window{Value: 1, Unit: Months}, day(2024, time.March, 15), day(2024, time.February, 15)},
+		{"across the year end", Window{Value: 3, Unit: Months}, day(2024, time.January, 31), day(2023, time.October, 31)},
+		{"month end into leap february", Window{Value: 1, Unit: Months}, day(2024, time.March, 31), day(2024, time.February, 29)},
+		{"month end into common february", Window{Value: 1, Unit: Months}, day(2023, time.March, 31), day(2023, time.February, 28)},
+		{"leap day back a year", Window{Value: 12, Unit: Months}, day(2024, time.February, 29), day(2023, time.February, 28)},
+		{"month end into shorter month", Window{Value: 1, Unit: Months}, day(2024, time.May, 31), day(2024, time.April, 30)},
+		{"month end into longer month", Window{Value: 1, Unit: Months}, day(2024, time.April, 30), day(2024, time.March, 30)},
+		{"zero months", Window{Value: 0, Unit: Months}, day(2024, time.March, 31), day(2024, time.March, 31)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Before(tt.t); !got.Equal(tt.want) {
+				t.Errorf("%s before %s = %s, want %s", tt.window, tt.t.Format(time.DateOnly), got.Format(time.DateOnly), tt.want.Format(time.DateOnly))
+			}
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Window
+		wantErr bool
+	}{
+		{in: "90d", want: Window{Value: 90, Unit: Days}},
+		{in: "12w", want: Window{Value: 12, Unit: Weeks}},
+		{in: "6m", want: Window{Value: 6, Unit: Months}},
+		{in: "6M", want: Window{Value: 6, Unit: Months}},
+		{in: "", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "0d", wantErr: true},
+		{in: "-3m", wantErr: true},
+		{in: "3y", wantErr: true},
+		{in: "m3", wantErr: true},
+		{in: "1.5m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseWindow(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseWindow(%q) = %v, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseWindow(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultModes(t *testing.T) {
+	now := day(2024, time.June, 30)
+
+	tests := []struct {
+		name        string
+		ua          *models.UserActions
+		gap         bool
+		sinceLast   bool
+		bothReasons []string
+	}{
+		{
+			name: "no actions",
+			ua:   &models.UserActions{},
+		},
+		{
+			name: "recent actions close together",
+			ua:   actions(day(2024, time.June, 20), day(2024, time.June, 1)),
+		},
+		{
+			name:        "recent action after a long gap",
+			ua:          actions(day(2024, time.June, 20), day(2024, time.January, 10)),
+			gap:         true,
+			bothReasons: []string{"gap"},
+		},
+		{
+			name:        "old actions close together",
+			ua:          actions(day(2024, time.January, 20), day(2024, time.January, 10)),
+			sinceLast:   true,
+			bothReasons: []string{"since-last"},
+		},
+		{
+			name:        "single old action",
+			ua:          actions(day(2024, time.January, 20)),
+			gap:         true,
+			sinceLast:   true,
+			bothReasons: []string{"gap", "since-last"},
+		},
+		{
+			name: "gap of exactly the window",
+			ua:   actions(day(2024, time.May, 31), day(2024, time.February, 29)),
+			gap:  true,
+			// The latest action is within three months of now.
+			bothReasons: []string{"gap"},
+		},
+		{
+			name: "gap a day short of the window",
+			ua:   actions(day(2024, time.May, 31), day(2024, time.March, 1)),
+		},
+		{
+			name:        "last action exactly the window ago",
+			ua:          actions(day(2024, time.March, 30)),
+			gap:         true,
+			bothReasons: []string{"gap"},
+		},
+		{
+			name:        "last action a day over the window",
+			ua:          actions(day(2024, time.March, 29)),
+			gap:         true,
+			sinceLast:   true,
+			bothReasons: []string{"gap", "since-last"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Default(3, ModeGap).Matches(tt.ua, now); got != tt.gap {
+				t.Errorf("gap = %t, want %t", got, tt.gap)
+			}
+			if got := Default(3, ModeSinceLast).Matches(tt.ua, now); got != tt.sinceLast {
+				t.Errorf("since-last = %t, want %t", got, tt.sinceLast)
+			}
+			matched, reasons := Default(3, ModeBoth).Evaluate(tt.ua, now)
+			if matched != (tt.gap || tt.sinceLast) || !slices.Equal(reasons, tt.bothReasons) {
+				t.Errorf("both = %t %v, want %t %v", matched, reasons, tt.gap || tt.sinceLast, tt.bothReasons)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, s := range []string{"gap", "since-last", "both"} {
+		if m, err := ParseMode(s); err != nil || string(m) != s {
+			t.Errorf("ParseMode(%q) = %q, %v", s, m, err)
+		}
+	}
+	for _, s := range []string{"", "Gap", "since_last", "any"} {
+		if _, err := ParseMode(s); err == nil {
+			t.Errorf("ParseMode(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	now := day(2024, time.June, 30)
+	rule := Rule{
+		Name: "lapsed-bettor",
+		Conditions: []Condition{
+			{Action: AnyAction, InactiveFor: &Window{Value: 30, Unit: Days}},
+			{Action: TopUp, ActiveWithin: &Window{Value: 2, Unit: Months}},
+			{Action: Bet, InactiveFor: &Window{Value: 4, Unit: Weeks}},
+		},
+	}
+	topUp := models.Action{CreatedAt: day(2024, time.May, 15).Unix()}
+	bet := models.Action{CreatedAt: day(2024, time.March, 1).Unix()}
+
+	tests := []struct {
+		name string
+		ua   *models.UserActions
+		want bool
+	}{
+		{"no actions", nil, false},
+		{"every condition holds", &models.UserActions{Recent: []models.Action{topUp, bet}, TopUp: &topUp, Bet: &bet}, true},
+		{
+			"recent action",
+			&models.UserActions{
+				Recent: []models.Action{{CreatedAt: day(2024, time.June, 25).Unix()}, topUp, bet},
+				TopUp:  &topUp, Bet: &bet,
+			},
+			false,
+		},
+		{"no top-up", &models.UserActions{Recent: []models.Action{bet}, Bet: &bet}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rule.Matches(tt.ua, now); got != tt.want {
+				t.Errorf("Matches = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSet(t *testing.T) {
+	valid := Rule{Name: "idle", Conditions: []Condition{{Action: AnyAction, InactiveFor: &Window{Value: 30, Unit: Days}}}}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"reserved name", []Rule{{Name: DefaultName, Conditions: valid.Conditions}}},
+		{"duplicate", []Rule{valid, valid}},
+		{"no conditions", []Rule{{Name: "empty"}}},
+		{"two windows", []Rule{{Name: "both", Conditions: []Condition{{
+			Action: AnyAction, InactiveFor: &Window{Value: 1, Unit: Days}, ActiveWithin: &Window{Value: 1, Unit: Days},
+		}}}}},
+		{"gap on a scope", []Rule{{Name: "gap", Conditions: []Condition{{Action: Bet, GapAtLeast: &Window{Value: 1, Unit: Months}}}}}},
+		{"unknown unit", []Rule{{Name: "years", Conditions: []Condition{{Action: AnyAction, InactiveFor: &Window{Value: 1, Unit: "years"}}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSet(tt.rules...); err == nil {
+				t.Errorf("NewSet succeeded, want error")
+			}
+		})
+	}
+
+	s, err := NewSet(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := s.Get("idle"); !ok || r.Name != "idle" {
+		t.Errorf("Get(idle) = %+v, %t", r, ok)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	now := day(2024, time.June, 30)
+	rule := Rule{
+		Name:  "lapsed-bettor",
+		Match: MatchAll,
+		Conditions: []Condition{
+			{Action: AnyAction, InactiveFor: &Window{Value: 30, Unit: Days}},
+			{Name: "still depositing", Action: TopUp, ActiveWithin: &Window{Value: 2, Unit: Months}},
+			{Action: Bet, InactiveFor: &Window{Value: 4, Unit: Weeks}},
+		},
+	}
+	topUp := models.Action{CreatedAt: day(2024, time.May, 15).Unix()}
+	bet := models.Action{CreatedAt: day(2024, time.March, 1).Unix()}
+
+	tests := []struct {
+		name    string
+		ua      *models.UserActions
+		want    []bool
+		matched bool
+	}{
+		{
+			name: "no actions",
+			ua:   nil,
+			want: []bool{false, false, false},
+		},
+		{
+			name:    "every condition holds",
+			ua:      &models.UserActions{Recent: []models.Action{topUp, bet}, TopUp: &topUp, Bet: &bet},
+			want:    []bool{true, true, true},
+			matched: true,
+		},
+		{
+			// Evaluate stops at the first condition that fails; Explain
+			// still reports the ones after it.
+			name: "first condition fails",
+			ua: &models.UserActions{
+				Recent: []models.Action{{CreatedAt: day(2024, time.June, 25).Unix()}, topUp, bet},
+				TopUp:  &topUp, Bet: &bet,
+			},
+			want: []bool{false, true, true},
+		},
+		{
+			name: "no top-up",
+			ua:   &models.UserActions{Recent: []models.Action{bet}, Bet: &bet},
+			want: []bool{true, false, true},
+		},
+	}
+
+	labels := []string{"no any action within 30 days", "still depositing", "no bet action within 4 weeks"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := rule.Explain(tt.ua, now)
+			if len(out) != len(rule.Conditions) {
+				t.Fatalf("Explain returned %d outcomes, want %d", len(out), len(rule.Conditions))
+			}
+			for i, o := range out {
+				if o.Condition != labels[i] || o.Held != tt.want[i] {
+					t.Errorf("outcome %d = %+v, want {%s %t}", i, o, labels[i], tt.want[i])
+				}
+			}
+			if got := rule.Matches(tt.ua, now); got != tt.matched {
+				t.Errorf("Matches = %t, want %t", got, tt.matched)
+			}
+		})
+	}
+}